@@ -5,6 +5,7 @@ go 1.24.0
 toolchain go1.24.11
 
 require (
+	github.com/ToniBirat7/tesseract_ocr_ne v0.0.0-00010101000000-000000000000
 	github.com/google/generative-ai-go v0.20.1
 	github.com/joho/godotenv v1.5.1
 	github.com/otiai10/gosseract/v2 v2.4.1
@@ -43,3 +44,6 @@ require (
 	google.golang.org/grpc v1.77.0 // indirect
 	google.golang.org/protobuf v1.36.11 // indirect
 )
+
+// The OCR library lives next to this module in the same repository
+replace github.com/ToniBirat7/tesseract_ocr_ne => ../go-tesseract
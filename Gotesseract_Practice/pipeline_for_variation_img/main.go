@@ -5,20 +5,21 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strings"
-)
+	"unicode"
 
-// Regex patterns pre-compiled for performance
-var (
-	// Clean non-Devanagari text, keeping numbers and punctuation
-	regexGibberish = regexp.MustCompile(`[^\x{0900}-\x{097F}0-9\s.,?!।\-()]+`)
-	// Ensure at least one Devanagari character exists
-	regexHasDevanagari = regexp.MustCompile(`[\x{0900}-\x{097F}]`)
-	// Reduce multiple spaces
-	regexMultiSpace = regexp.MustCompile(`\s+`)
+	"github.com/ToniBirat7/tesseract_ocr_ne/pkg/textfilter"
 )
 
+// cleaner repairs Nepali Unicode artifacts (danda, nukta, matras), keeps
+// Devanagari, digits and basic punctuation, normalizes spacing and drops
+// tokens left without any Devanagari character
+var cleaner = textfilter.Chain{
+	textfilter.NepaliNormalizer{},
+	textfilter.DevanagariCleanup(),
+	textfilter.RequireScript{Scripts: []*unicode.RangeTable{textfilter.Devanagari}},
+}
+
 func main() {
 	// --- CONFIGURATION ---
 	imagesDir := "../variation_imgs"
@@ -151,16 +152,11 @@ func processLevel(baseDir, subFolder, inputFile, outputFile, levelType string) {
 		}
 
 		textPart := parts[1]
-		cleaned := cleanText(textPart)
-
+		cleaned := cleaner.Filter(textPart)
 		if cleaned == "" {
 			continue
 		}
 
-		if !regexHasDevanagari.MatchString(cleaned) {
-			continue
-		}
-
 		validTokens = append(validTokens, cleaned)
 	}
 
@@ -188,9 +184,3 @@ func processLevel(baseDir, subFolder, inputFile, outputFile, levelType string) {
 
 	os.WriteFile(outputPath, []byte(finalContent), 0644)
 }
-
-func cleanText(input string) string {
-	s := regexGibberish.ReplaceAllString(input, " ")
-	s = regexMultiSpace.ReplaceAllString(s, " ")
-	return strings.TrimSpace(s)
-}
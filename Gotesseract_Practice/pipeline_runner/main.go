@@ -5,24 +5,21 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strings"
-)
-
-// Regex patterns pre-compiled for performance
-var (
-	// FIXED REGEX: Removed unnecessary backslashes.
-	// We keep \s (whitespace) and \- (hyphen).
-	// The rest (.,?!।()) are treated as literals inside [].
-	regexGibberish = regexp.MustCompile(`[^\x{0900}-\x{097F}0-9\s.,?!।\-()]+`)
+	"unicode"
 
-	// Helper to check if a string contains at least one Devanagari character.
-	regexHasDevanagari = regexp.MustCompile(`[\x{0900}-\x{097F}]`)
-
-	// Reduce multiple spaces to one
-	regexMultiSpace = regexp.MustCompile(`\s+`)
+	"github.com/ToniBirat7/tesseract_ocr_ne/pkg/textfilter"
 )
 
+// cleaner repairs Nepali Unicode artifacts (danda, nukta, matras), keeps
+// Devanagari, digits and basic punctuation, normalizes spacing and drops
+// tokens left without any Devanagari character
+var cleaner = textfilter.Chain{
+	textfilter.NepaliNormalizer{},
+	textfilter.DevanagariCleanup(),
+	textfilter.RequireScript{Scripts: []*unicode.RangeTable{textfilter.Devanagari}},
+}
+
 func main() {
 	// --- CONFIGURATION ---
 	imagesDir := "../rajpatra_imgs"
@@ -112,20 +109,14 @@ func processLevel(baseDir, subFolder, inputFile, outputFile, levelType string) {
 
 		textPart := parts[1] // The middle part is the text
 
-		// --- STEP 1: Cleaning ---
-		cleaned := cleanText(textPart)
-
-		// --- STEP 2: Filter Noise ---
+		// --- STEP 1 & 2: Clean and Filter Noise ---
+		// Lines with NO Devanagari characters (only numbers or punctuation)
+		// come back empty, to ensure the corpus is high quality language data.
+		cleaned := cleaner.Filter(textPart)
 		if cleaned == "" {
 			continue
 		}
 
-		// If the line has NO Devanagari characters (only numbers or punctuation),
-		// we skip it to ensure the corpus is high quality language data.
-		if !regexHasDevanagari.MatchString(cleaned) {
-			continue
-		}
-
 		validTokens = append(validTokens, cleaned)
 	}
 
@@ -163,16 +154,3 @@ func processLevel(baseDir, subFolder, inputFile, outputFile, levelType string) {
 		fmt.Printf("Failed to write %s: %v\n", outputFile, err)
 	}
 }
-
-// cleanText applies regex to remove non-Devanagari noise
-func cleanText(input string) string {
-	// 1. Remove non-allowed characters (English letters, weird symbols)
-	// We replace them with space to avoid merging words accidentally
-	s := regexGibberish.ReplaceAllString(input, " ")
-
-	// 2. Normalize spaces (turn "   " into " ")
-	s = regexMultiSpace.ReplaceAllString(s, " ")
-
-	// 3. Trim edges
-	return strings.TrimSpace(s)
-}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ToniBirat7/tesseract_ocr_ne/pkg/apikey"
+	"github.com/ToniBirat7/tesseract_ocr_ne/pkg/jobs"
+	"github.com/gofiber/fiber/v2"
+)
+
+// apiKeys and usage are nil when api_keys is not set and the API is open
+var (
+	apiKeys *apikey.Keyring
+	usage   *apikey.Limiter
+)
+
+// publicPaths are answered without a key
+var publicPaths = map[string]bool{
+	"/":             true,
+	"/health":       true,
+	"/health/live":  true,
+	"/health/ready": true,
+	"/metrics":      true,
+}
+
+// ocrPaths are the POST routes that run OCR and are charged to the daily
+// quota; every other request only counts against the rate limit
+var ocrPaths = map[string]bool{
+	"/ocr/extract": true,
+	"/ocr/pdf":     true,
+	"/ocr/batch":   true,
+	"/ocr/jobs":    true,
+}
+
+// usageSaveInterval is how often key usage is written to the job database
+const usageSaveInterval = 5 * time.Second
+
+// openAPIKeys loads the key file named by api_keys (OCR_API_KEYS) and
+// restores the usage counters saved in store
+func openAPIKeys(store *jobs.Store) error {
+	path := cfg.APIKeys
+	if path == "" {
+		log.Printf("No API key file (OCR_API_KEYS) is set: the API is open to anyone who can reach it")
+		return nil
+	}
+	kr, err := apikey.LoadFile(path)
+	if err != nil {
+		return err
+	}
+	usageStore, err := apikey.NewUsageStore(store.DB())
+	if err != nil {
+		return err
+	}
+	limiter := apikey.NewLimiter(kr)
+	if err := limiter.Persist(usageStore, usageSaveInterval); err != nil {
+		return err
+	}
+	apiKeys = kr
+	usage = limiter
+	log.Printf("Loaded %d API keys", len(kr.Keys()))
+	return nil
+}
+
+// requireAPIKey checks the key sent as "Authorization: Bearer <key>" or
+// "X-API-Key: <key>" and charges the request to it. Only OCR requests use
+// up the daily quota
+func requireAPIKey(c *fiber.Ctx) error {
+	if apiKeys == nil || publicPaths[c.Path()] || c.Method() == fiber.MethodOptions {
+		return c.Next()
+	}
+
+	key := apiKeys.Authenticate(requestAPIKey(c))
+	if key == nil {
+		c.Set(fiber.HeaderWWWAuthenticate, `Bearer realm="ocr-api"`)
+		return &requestError{
+			Status:        fiber.StatusUnauthorized,
+			ErrorResponse: ErrorResponse{Error: "unauthorized", Message: "A valid API key is required (Authorization: Bearer <key> or X-API-Key)"},
+		}
+	}
+
+	var decision apikey.Decision
+	if c.Method() == fiber.MethodPost && ocrPaths[routePath(c.Path())] {
+		decision = usage.Allow(key)
+	} else {
+		decision = usage.Throttle(key)
+	}
+	if key.DailyQuota > 0 {
+		c.Set("X-RateLimit-Limit", strconv.Itoa(key.DailyQuota))
+		c.Set("X-RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+	}
+	switch decision.Verdict {
+	case apikey.RateLimited:
+		c.Set(fiber.HeaderRetryAfter, retryAfter(decision))
+		return &requestError{
+			Status:        fiber.StatusTooManyRequests,
+			ErrorResponse: ErrorResponse{Error: "rate_limited", Message: fmt.Sprintf("Too many requests for key %s, retry in %ss", key.ID, retryAfter(decision))},
+		}
+	case apikey.QuotaExceeded:
+		c.Set(fiber.HeaderRetryAfter, retryAfter(decision))
+		return &requestError{
+			Status:        fiber.StatusTooManyRequests,
+			ErrorResponse: ErrorResponse{Error: "quota_exceeded", Message: fmt.Sprintf("Daily quota of %d requests used up for key %s", key.DailyQuota, key.ID)},
+		}
+	}
+
+	c.Locals("apiKey", key)
+	return c.Next()
+}
+
+// requestAPIKey returns the key sent with the request, if any
+func requestAPIKey(c *fiber.Ctx) string {
+	if auth := c.Get(fiber.HeaderAuthorization); len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return c.Get("X-API-Key")
+}
+
+// retryAfter is the Retry-After header value, in whole seconds
+func retryAfter(d apikey.Decision) string {
+	return strconv.Itoa(int(math.Ceil(d.RetryAfter.Seconds())))
+}
+
+// callerKey is the API key of the request, nil when keys are off
+func callerKey(c *fiber.Ctx) *apikey.Key {
+	key, _ := c.Locals("apiKey").(*apikey.Key)
+	return key
+}
+
+// callerID is the ID of the request's API key, empty when keys are off
+func callerID(c *fiber.Ctx) string {
+	if key := callerKey(c); key != nil {
+		return key.ID
+	}
+	return ""
+}
+
+// canAccess reports whether the caller may see a job or delivery of owner:
+// anyone when keys are off, otherwise the owner and admin keys
+func canAccess(c *fiber.Ctx, owner string) bool {
+	if apiKeys == nil {
+		return true
+	}
+	key := callerKey(c)
+	return key != nil && (key.Admin || key.ID == owner)
+}
+
+// handleAdminUsage returns the usage counters of every key; admin keys only
+func handleAdminUsage(c *fiber.Ctx) error {
+	if apiKeys == nil {
+		return &requestError{
+			Status:        fiber.StatusNotFound,
+			ErrorResponse: ErrorResponse{Error: "not_found", Message: "API keys are not enabled (OCR_API_KEYS)"},
+		}
+	}
+	if key := callerKey(c); key == nil || !key.Admin {
+		return &requestError{
+			Status:        fiber.StatusForbidden,
+			ErrorResponse: ErrorResponse{Error: "forbidden", Message: "Usage is only available to admin keys"},
+		}
+	}
+	return c.JSON(usage.Usage())
+}
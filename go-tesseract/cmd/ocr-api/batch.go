@@ -0,0 +1,323 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// BatchItem is the outcome of one file of a batch: a result or an error
+type BatchItem struct {
+	Index      int            `json:"index"`
+	File       string         `json:"file"`
+	Result     any            `json:"result,omitempty"`
+	Error      *ErrorResponse `json:"error,omitempty"`
+	DurationMS int64          `json:"duration_ms"`
+}
+
+// BatchResponse holds every item of a batch in upload order
+type BatchResponse struct {
+	Total     int         `json:"total"`
+	Succeeded int         `json:"succeeded"`
+	Failed    int         `json:"failed"`
+	Items     []BatchItem `json:"items"`
+}
+
+// BatchSummary ends an SSE batch stream with the counts of BatchResponse
+type BatchSummary struct {
+	Total      int   `json:"total"`
+	Succeeded  int   `json:"succeeded"`
+	Failed     int   `json:"failed"`
+	DurationMS int64 `json:"duration_ms"`
+}
+
+// batchFile is one file of a batch, read lazily so only the files being
+// processed are held in memory; err is set for files rejected up front
+type batchFile struct {
+	name string
+	open func() (*upload, error)
+	err  error
+}
+
+// handleOCRBatch runs OCR on every uploaded file ("images", "image" or
+// ZIP archives in "archive") with the form options of /ocr/extract. A bad
+// file fails its own item, not the batch. Streamed items are sent as soon
+// as they are done: in NDJSON mode as one bare BatchItem per line, in SSE
+// mode as "item" events followed by a "summary" event
+func handleOCRBatch(c *fiber.Ctx) error {
+	form, err := c.MultipartForm()
+	if err != nil {
+		return badRequest("Send the files as multipart/form-data in 'images' or a ZIP in 'archive'")
+	}
+
+	var headers []*multipart.FileHeader
+	for _, field := range []string{"images", "image", "archive"} {
+		headers = append(headers, form.File[field]...)
+	}
+	if len(headers) == 0 {
+		return badRequest("No files provided. Use 'images' fields or a ZIP in 'archive'")
+	}
+
+	files, err := batchFiles(headers)
+	if err != nil {
+		return err
+	}
+	stream, err := streamMode(c)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+
+	config, err := formConfig(form.Value)
+	if err != nil {
+		return err
+	}
+	layoutMode := url.Values(form.Value).Get("layout") == "true"
+	if layoutMode {
+		layoutConfig(config)
+	}
+
+	process := func(ctx context.Context, i int) BatchItem {
+		start := time.Now()
+		item := BatchItem{Index: i, File: files[i].name}
+		if err := ctx.Err(); err != nil {
+			item.Error = errorResponse(err)
+			return item
+		}
+
+		file, err := files[i].load()
+		if err == nil {
+			item.Result, err = runOCR(ctx, file, config)
+			if err == nil && layoutMode {
+				item.Result = analyzeLayout(item.Result)
+			}
+		}
+		if err != nil {
+			item.Error = errorResponse(err)
+		}
+		item.DurationMS = time.Since(start).Milliseconds()
+		return item
+	}
+
+	ctx := c.UserContext()
+	if stream != "" {
+		setStreamHeaders(c, stream)
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			if stream == mimeNDJSON {
+				enc := json.NewEncoder(w)
+				runBatch(ctx, len(files), process, func(item BatchItem) error {
+					if err := enc.Encode(item); err != nil {
+						return err
+					}
+					return w.Flush()
+				})
+				return
+			}
+
+			start := time.Now()
+			out := &eventWriter{w: w, sse: true}
+			summary := BatchSummary{Total: len(files)}
+			err := runBatch(ctx, len(files), process, func(item BatchItem) error {
+				if item.Error != nil {
+					summary.Failed++
+				} else {
+					summary.Succeeded++
+				}
+				return out.send("item", item)
+			})
+			if err != nil {
+				// The client is gone or the request was canceled
+				return
+			}
+			summary.DurationMS = time.Since(start).Milliseconds()
+			out.send("summary", summary)
+		})
+		return nil
+	}
+
+	resp := BatchResponse{Total: len(files), Items: make([]BatchItem, len(files))}
+	runBatch(ctx, len(files), process, func(item BatchItem) error {
+		resp.Items[item.Index] = item
+		if item.Error != nil {
+			resp.Failed++
+		} else {
+			resp.Succeeded++
+		}
+		return nil
+	})
+	return c.JSON(resp)
+}
+
+// batchBodyLimit raises the body limit to limits.max_batch_mb for
+// POST /ocr/batch. It runs on the request headers, before the body is read
+func batchBodyLimit(h *fasthttp.RequestHeader) fasthttp.RequestConfig {
+	path, _, _ := strings.Cut(string(h.RequestURI()), "?")
+	if h.IsPost() && routePath(path) == "/ocr/batch" {
+		return fasthttp.RequestConfig{MaxRequestBodySize: cfg.MaxBatchSize()}
+	}
+	return fasthttp.RequestConfig{}
+}
+
+// runBatch processes n files with at most ocr.batch_workers at a time and hands
+// every item to done, one at a time, in the order they finish. The first
+// error from done, or ctx ending, stops the batch: the running files are
+// canceled so they give back their engine slots, the rest fail without
+// being read, and the error is returned
+func runBatch(ctx context.Context, n int, process func(context.Context, int) BatchItem, done func(BatchItem) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	items := make(chan BatchItem)
+	sem := make(chan struct{}, max(cfg.OCR.BatchWorkers, 1))
+	var wg sync.WaitGroup
+
+	go func() {
+		for i := 0; i < n; i++ {
+			sem <- struct{}{}
+			wg.Add(1)
+			go func(i int) {
+				defer func() { <-sem; wg.Done() }()
+				items <- process(ctx, i)
+			}(i)
+		}
+		wg.Wait()
+		close(items)
+	}()
+
+	var err error
+	for item := range items {
+		if err != nil {
+			// Drain the canceled files
+			continue
+		}
+		if err = done(item); err != nil {
+			cancel()
+		}
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	return err
+}
+
+// load reads the file, or returns the reason it was rejected
+func (f batchFile) load() (*upload, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.open()
+}
+
+// batchFiles lists the files of a batch, expanding ZIP archives into
+// their entries. Entries are checked like uploads; bad ones become failed
+// items rather than failing the batch
+func batchFiles(headers []*multipart.FileHeader) ([]batchFile, error) {
+	var files []batchFile
+	for _, h := range headers {
+		if !strings.EqualFold(filepath.Ext(h.Filename), ".zip") {
+			h := h
+			files = append(files, batchFile{
+				name: h.Filename,
+				open: func() (*upload, error) { return openUpload(h) },
+				err:  checkSize(h.Size),
+			})
+			continue
+		}
+
+		entries, err := zipEntries(h)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, entries...)
+	}
+
+	if len(files) > cfg.Limits.MaxBatchFiles {
+		return nil, badRequest(fmt.Sprintf("A batch may hold at most %d files", cfg.Limits.MaxBatchFiles))
+	}
+	return files, nil
+}
+
+// zipEntries lists the files inside an uploaded ZIP archive. Folders and
+// macOS resource forks are skipped
+func zipEntries(h *multipart.FileHeader) ([]batchFile, error) {
+	data, err := readFormFile(h)
+	if err != nil {
+		return nil, &requestError{
+			Status:        fiber.StatusInternalServerError,
+			ErrorResponse: ErrorResponse{Error: "upload_failed", Message: "Failed to read uploaded file"},
+		}
+	}
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, &requestError{
+			Status:        fiber.StatusBadRequest,
+			ErrorResponse: ErrorResponse{Error: "invalid_archive", Message: fmt.Sprintf("%s is not a valid ZIP archive", h.Filename)},
+		}
+	}
+
+	var files []batchFile
+	for _, entry := range archive.File {
+		if entry.FileInfo().IsDir() || strings.HasPrefix(entry.Name, "__MACOSX/") || strings.HasPrefix(path.Base(entry.Name), "._") {
+			continue
+		}
+		entry := entry
+		files = append(files, batchFile{
+			name: h.Filename + "/" + entry.Name,
+			open: func() (*upload, error) { return openZipEntry(entry) },
+			err:  checkSize(int64(entry.UncompressedSize64)),
+		})
+	}
+	return files, nil
+}
+
+// openZipEntry decompresses one entry, never reading more than
+// limits.max_upload_mb whatever its header claims
+func openZipEntry(entry *zip.File) (*upload, error) {
+	failed := &requestError{
+		Status:        fiber.StatusBadRequest,
+		ErrorResponse: ErrorResponse{Error: "invalid_archive", Message: "Failed to extract " + entry.Name},
+	}
+	r, err := entry.Open()
+	if err != nil {
+		return nil, failed
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(io.LimitReader(r, cfg.MaxUploadSize()+1))
+	if err != nil {
+		return nil, failed
+	}
+	if err := checkSize(int64(len(data))); err != nil {
+		return nil, err
+	}
+	file := &upload{name: path.Base(entry.Name), ext: filepath.Ext(entry.Name), data: data}
+	if err := inspectUpload(file); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// errorResponse is the body an error would be answered with
+func errorResponse(err error) *ErrorResponse {
+	var re *requestError
+	if errors.As(err, &re) {
+		return &re.ErrorResponse
+	}
+	log.Printf("Batch item error: %v", err)
+	return &ErrorResponse{Error: "internal_error", Message: err.Error()}
+}
@@ -0,0 +1,11 @@
+//go:build !(linux || darwin || freebsd)
+
+package main
+
+import "errors"
+
+// freeDiskSpace is not implemented on systems whose syscall.Statfs is
+// missing or shaped differently, such as Windows, OpenBSD and Solaris
+func freeDiskSpace(path string) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
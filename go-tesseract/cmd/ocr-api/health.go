@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ToniBirat7/tesseract_ocr_ne/pkg/ocr"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Build information, set with -ldflags "-X main.version=... -X main.commit=..."
+var (
+	version = "1.0.0"
+	commit  = ""
+)
+
+// languageCheckTTL is how long a successful language check is trusted
+const languageCheckTTL = time.Minute
+
+type HealthResponse struct {
+	Status  string `json:"status"`
+	Version string `json:"version"`
+	Time    string `json:"time"`
+}
+
+// BuildInfo describes the running binary and the OCR libraries it uses
+type BuildInfo struct {
+	Version   string   `json:"version"`
+	Commit    string   `json:"commit,omitempty"`
+	GoVersion string   `json:"go_version"`
+	Tesseract string   `json:"tesseract"`
+	Leptonica string   `json:"leptonica,omitempty"`
+	Languages []string `json:"languages"`
+}
+
+// Check is the outcome of one readiness check
+type Check struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// ReadinessResponse lists every check; Status is "ready" only when all passed
+type ReadinessResponse struct {
+	Status string           `json:"status"`
+	Checks map[string]Check `json:"checks"`
+	Build  BuildInfo        `json:"build"`
+	Time   string           `json:"time"`
+}
+
+// health holds what the readiness checks need, set up by setupHealth
+var health struct {
+	languages   []string
+	uploadDir   string
+	minFreeDisk uint64
+	build       BuildInfo
+
+	mu          sync.Mutex
+	languagesOK time.Time
+}
+
+// setupHealth takes the languages that must load (ocr.languages, e.g.
+// [nep, nep+eng]) and the free disk space required in dataDir
+// (limits.min_free_mb) from the config, and looks up the library versions once
+func setupHealth(dataDir string) error {
+	for _, entry := range cfg.OCR.Languages {
+		langs, err := ocr.ParseLanguages(entry)
+		if err != nil {
+			return fmt.Errorf("invalid ocr.languages: %w", err)
+		}
+		health.languages = append(health.languages, strings.Join(langs, "+"))
+	}
+	health.minFreeDisk = cfg.Limits.MinFreeMB << 20
+	health.uploadDir = filepath.Join(dataDir, "uploads")
+
+	versions := ocr.LibraryVersions()
+	if versions.Leptonica == "" {
+		versions.Leptonica = "unknown"
+	}
+	health.build = BuildInfo{
+		Version:   version,
+		Commit:    buildCommit(),
+		GoVersion: runtime.Version(),
+		Tesseract: versions.Tesseract,
+		Leptonica: versions.Leptonica,
+	}
+	log.Printf("Tesseract %s, Leptonica %s", versions.Tesseract, versions.Leptonica)
+	return nil
+}
+
+// buildCommit is the commit set with -ldflags, or else the one the Go
+// toolchain stamped into the binary
+func buildCommit() string {
+	if commit != "" {
+		return commit
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" {
+				return s.Value
+			}
+		}
+	}
+	return ""
+}
+
+// handleHealth is the liveness probe: the process is up and serving
+func handleHealth(c *fiber.Ctx) error {
+	return c.JSON(HealthResponse{
+		Status:  "healthy",
+		Version: version,
+		Time:    time.Now().Format(time.RFC3339),
+	})
+}
+
+// handleReady is the readiness probe. It answers 503 unless Tesseract loads
+// every configured language, uploads can be written, the disk has room and
+// the OCR pools and job queue can take more work
+func handleReady(c *fiber.Ctx) error {
+	checks := map[string]Check{
+		"tesseract":  checkLanguages(c.UserContext()),
+		"upload_dir": checkUploadDir(),
+		"disk":       checkDisk(),
+		"ocr_pool":   checkPools(),
+		"job_queue":  checkJobQueue(),
+	}
+
+	resp := ReadinessResponse{Status: "ready", Checks: checks, Build: health.build, Time: time.Now().Format(time.RFC3339)}
+	resp.Build.Languages = []string{}
+	if langs, err := ocr.AvailableLanguages(); err == nil && langs != nil {
+		resp.Build.Languages = langs
+	}
+
+	status := fiber.StatusOK
+	for _, check := range checks {
+		if check.Status != "ok" {
+			resp.Status = "not_ready"
+			status = fiber.StatusServiceUnavailable
+		}
+	}
+	return c.Status(status).JSON(resp)
+}
+
+func passed() Check {
+	return Check{Status: "ok"}
+}
+
+func failed(format string, args ...any) Check {
+	return Check{Status: "failed", Message: fmt.Sprintf(format, args...)}
+}
+
+// checkLanguages initializes Tesseract with every configured language.
+// Loading traineddata is slow, so a success is reused for languageCheckTTL.
+// The check runs without holding health.mu, so probes that overlap a slow
+// check may run their own instead of queueing behind it
+func checkLanguages(ctx context.Context) Check {
+	health.mu.Lock()
+	fresh := time.Since(health.languagesOK) < languageCheckTTL
+	health.mu.Unlock()
+	if fresh {
+		return passed()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	var problems []string
+	for _, lang := range health.languages {
+		if err := ocr.CheckLanguage(ctx, lang); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if len(problems) > 0 {
+		return failed("%s", strings.Join(problems, "; "))
+	}
+	health.mu.Lock()
+	health.languagesOK = time.Now()
+	health.mu.Unlock()
+	return passed()
+}
+
+// checkUploadDir writes and removes a file where job uploads are kept
+func checkUploadDir() Check {
+	if err := os.MkdirAll(health.uploadDir, 0755); err != nil {
+		return failed("cannot create %s: %v", health.uploadDir, err)
+	}
+	f, err := os.CreateTemp(health.uploadDir, ".ready-*")
+	if err != nil {
+		return failed("%s is not writable: %v", health.uploadDir, err)
+	}
+	_, err = f.WriteString("ok")
+	f.Close()
+	os.Remove(f.Name())
+	if err != nil {
+		return failed("%s is not writable: %v", health.uploadDir, err)
+	}
+	return passed()
+}
+
+// checkDisk requires limits.min_free_mb free where uploads are kept
+func checkDisk() Check {
+	free, err := freeDiskSpace(health.uploadDir)
+	if err != nil {
+		// Not knowing is no reason to take the server out of service
+		return Check{Status: "ok", Message: err.Error()}
+	}
+	if free < health.minFreeDisk {
+		return failed("%d MB free, %d MB required", free>>20, health.minFreeDisk>>20)
+	}
+	return passed()
+}
+
+// checkPools fails when as many requests wait for a Tesseract client of a
+// pool as the pool has clients
+func checkPools() Check {
+	for _, p := range engine.Stats().Pools {
+		if p.Waiting >= p.Capacity {
+			return failed("%d requests waiting for the %d %s clients", p.Waiting, p.Capacity, p.Language)
+		}
+	}
+	return passed()
+}
+
+// checkJobQueue fails when new jobs would be refused with queue_full
+func checkJobQueue() Check {
+	stats := jobQueue.Stats()
+	if stats.MaxPending > 0 && stats.Pending >= stats.MaxPending {
+		return failed("%d jobs waiting, the queue holds %d", stats.Pending, stats.MaxPending)
+	}
+	return passed()
+}
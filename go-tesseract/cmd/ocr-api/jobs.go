@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/ToniBirat7/tesseract_ocr_ne/pkg/jobs"
+	"github.com/ToniBirat7/tesseract_ocr_ne/pkg/ocr"
+	"github.com/gofiber/fiber/v2"
+)
+
+// jobQueue runs the asynchronous OCR jobs of POST /ocr/jobs
+var jobQueue *jobs.Queue
+
+// openJobQueue opens the job store in dataDir and starts the workers.
+// Uploads of unfinished jobs are kept in dataDir/uploads
+func openJobQueue(dataDir string) (*jobs.Queue, *jobs.Store, error) {
+	store, err := jobs.OpenStore(filepath.Join(dataDir, "jobs.db"))
+	if err != nil {
+		return nil, nil, err
+	}
+	queue, err := jobs.New(store, filepath.Join(dataDir, "uploads"), runJob, jobs.Options{
+		Workers:    cfg.Jobs.Workers,
+		MaxPending: cfg.Jobs.MaxQueue,
+		Timeout:    cfg.Jobs.Timeout,
+		Retention:  cfg.Jobs.Retention,
+		OnFinish:   notifyJob,
+	})
+	if err != nil {
+		store.Close()
+		return nil, nil, err
+	}
+	log.Printf("Job queue started with %d workers", cfg.Jobs.Workers)
+	return queue, store, nil
+}
+
+// runJob is the worker body: the same extraction as POST /ocr/extract,
+// reporting the pages done of PDFs and TIFFs as job progress
+func runJob(ctx context.Context, job *jobs.Job, input string, progress func(done, total int)) (any, error) {
+	data, err := os.ReadFile(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read input: %w", err)
+	}
+
+	form := url.Values(job.Params)
+	config, err := formConfig(form)
+	if err != nil {
+		return nil, err
+	}
+	config.Progress = progress
+	layoutMode := form.Get("layout") == "true"
+	if layoutMode {
+		layoutConfig(config)
+	}
+
+	result, err := extractUpload(ctx, &upload{name: job.FileName, ext: filepath.Ext(job.FileName), data: data, format: ocr.DetectFormat(data)}, config)
+	if err != nil {
+		return nil, err
+	}
+	if layoutMode {
+		return analyzeLayout(result), nil
+	}
+	return result, nil
+}
+
+// handleJobSubmit queues the upload and answers 202 with the job to poll
+func handleJobSubmit(c *fiber.Ctx) error {
+	file, err := readUpload(c)
+	if err != nil {
+		return err
+	}
+
+	// Reject bad options now rather than when a worker gets to the job
+	form := formValues(c)
+	if _, err := formConfig(form); err != nil {
+		return err
+	}
+	if err := callbackURL(form); err != nil {
+		return err
+	}
+
+	job, err := jobQueue.Submit(callerID(c), file.name, form, file.data)
+	if errors.Is(err, jobs.ErrQueueFull) {
+		return &requestError{
+			Status:        fiber.StatusServiceUnavailable,
+			ErrorResponse: ErrorResponse{Error: "queue_full", Message: "Too many jobs are waiting, try again later"},
+		}
+	}
+	if err != nil {
+		log.Printf("Job submit error: %v", err)
+		return &requestError{
+			Status:        fiber.StatusInternalServerError,
+			ErrorResponse: ErrorResponse{Error: "job_failed", Message: "Failed to queue the job"},
+		}
+	}
+
+	c.Location("/ocr/jobs/" + job.ID)
+	return c.Status(fiber.StatusAccepted).JSON(job)
+}
+
+// callerJob returns the job of the id path parameter. Jobs of other API
+// keys are not found, so their IDs do not leak
+func callerJob(c *fiber.Ctx) (*jobs.Job, error) {
+	job, err := jobQueue.Get(c.Params("id"))
+	if err != nil {
+		return nil, err
+	}
+	if !canAccess(c, job.Owner) {
+		return nil, jobs.ErrNotFound
+	}
+	return job, nil
+}
+
+// handleJobGet returns the status, progress and, once done, the result of a job
+func handleJobGet(c *fiber.Ctx) error {
+	job, err := callerJob(c)
+	if err != nil {
+		return jobError(err)
+	}
+	return c.JSON(job)
+}
+
+// handleJobCancel cancels a queued or running job
+func handleJobCancel(c *fiber.Ctx) error {
+	job, err := callerJob(c)
+	if err != nil {
+		return jobError(err)
+	}
+	job, err = jobQueue.Cancel(job.ID)
+	if err != nil {
+		return jobError(err)
+	}
+	return c.JSON(job)
+}
+
+// jobError maps job lookup errors to responses
+func jobError(err error) error {
+	switch {
+	case errors.Is(err, jobs.ErrNotFound):
+		return &requestError{
+			Status:        fiber.StatusNotFound,
+			ErrorResponse: ErrorResponse{Error: "job_not_found", Message: "No job with this ID"},
+		}
+	case errors.Is(err, jobs.ErrFinished):
+		return &requestError{
+			Status:        fiber.StatusConflict,
+			ErrorResponse: ErrorResponse{Error: "job_finished", Message: "The job has already finished"},
+		}
+	}
+	log.Printf("Job store error: %v", err)
+	return &requestError{
+		Status:        fiber.StatusInternalServerError,
+		ErrorResponse: ErrorResponse{Error: "internal_error", Message: "Failed to read the job"},
+	}
+}
@@ -1,48 +1,136 @@
 package main
 
 import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
 	"fmt"
+	"image"
+	"io"
 	"log"
+	"mime"
+	"mime/multipart"
+	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/ToniBirat7/tesseract_ocr_ne/internal/config"
+	"github.com/ToniBirat7/tesseract_ocr_ne/pkg/jobs"
+	"github.com/ToniBirat7/tesseract_ocr_ne/pkg/layout"
 	"github.com/ToniBirat7/tesseract_ocr_ne/pkg/ocr"
+	"github.com/ToniBirat7/tesseract_ocr_ne/pkg/textfilter"
+	"github.com/ToniBirat7/tesseract_ocr_ne/pkg/webhook"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
 )
 
-const (
-	maxUploadSize = 10 * 1024 * 1024 // 10MB
-	uploadDir     = "./uploads"
-)
-
 type ErrorResponse struct {
 	Error   string `json:"error"`
 	Message string `json:"message,omitempty"`
 }
 
-type HealthResponse struct {
-	Status  string `json:"status"`
-	Version string `json:"version"`
-	Time    string `json:"time"`
-}
+// cfg holds the settings loaded at startup
+var cfg *config.Config
+
+// engine keeps warm Tesseract clients shared by all requests
+var engine *ocr.Engine
+
+// lexicon backs spell correction when ocr.lexicon is set
+var lexicon *ocr.Lexicon
+
+// pdfFont is the Devanagari font embedded in searchable PDFs (ocr.pdf_font)
+var pdfFont string
 
 func main() {
-	// Create upload directory
-	if err := os.MkdirAll(uploadDir, 0755); err != nil {
-		log.Fatalf("Failed to create upload directory: %v", err)
+	// load config
+	cfg = config.MustLoad()
+
+	// Initialize OCR engine
+	engine = ocr.NewEngine(ocr.EngineOptions{
+		PoolSize:    cfg.OCR.PoolSize,
+		MaxPools:    cfg.OCR.MaxPools,
+		IdleTimeout: cfg.OCR.PoolIdleTimeout,
+	})
+	defer engine.Close()
+
+	pdfFont = cmp.Or(cfg.OCR.PDFFont, ocr.DefaultPDFFont)
+
+	// Load the spell correction wordlist, if any
+	var err error
+	if path := cfg.OCR.Lexicon; path != "" {
+		lexicon, err = ocr.LoadLexicon(path)
+		if err != nil {
+			log.Fatalf("Failed to load lexicon: %v", err)
+		}
+		log.Printf("Loaded lexicon with %d words", lexicon.Len())
+	}
+
+	shutdownTracing, err := setupTracing(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	// Start the callback dispatcher, then the asynchronous job workers
+	// whose finished jobs it delivers
+	dataDir := cfg.DataDir
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		log.Fatalf("Failed to create data directory: %v", err)
+	}
+	var webhookStore *webhook.Store
+	webhooks, webhookStore, err = openWebhooks(dataDir)
+	if err != nil {
+		log.Fatalf("Failed to start webhooks: %v", err)
+	}
+	if webhooks != nil {
+		defer webhookStore.Close()
+		defer webhooks.Close()
+	}
+
+	var jobStore *jobs.Store
+	jobQueue, jobStore, err = openJobQueue(dataDir)
+	if err != nil {
+		log.Fatalf("Failed to start job queue: %v", err)
+	}
+	defer jobStore.Close()
+	sweepUploads()
+
+	// Key usage is kept in the job database, so quotas survive a restart
+	if err := openAPIKeys(jobStore); err != nil {
+		log.Fatalf("Failed to load API keys: %v", err)
+	}
+	if usage != nil {
+		defer func() {
+			if err := usage.Close(); err != nil {
+				log.Printf("Failed to save key usage: %v", err)
+			}
+		}()
+	}
+
+	if err := setupHealth(dataDir); err != nil {
+		log.Fatalf("Invalid health check settings: %v", err)
 	}
 
 	// Initialize Fiber app
 	app := fiber.New(fiber.Config{
 		AppName:               "Go Tesseract OCR API",
-		BodyLimit:             maxUploadSize,
+		BodyLimit:             cfg.MaxRequestSize(),
+		ReadTimeout:           cfg.ReadTimeout,
+		WriteTimeout:          cfg.WriteTimeout,
+		IdleTimeout:           cfg.IdleTimeout,
 		DisableStartupMessage: false,
 		ErrorHandler:          customErrorHandler,
 	})
+	// Only a batch may be larger than one upload
+	app.Server().HeaderReceived = batchBodyLimit
 
 	// Middleware
 	app.Use(recover.New())
@@ -50,122 +138,533 @@ func main() {
 		Format:     "${time} | ${status} | ${latency} | ${method} ${path}\n",
 		TimeFormat: "2006-01-02 15:04:05",
 	}))
+	app.Use(tracingMiddleware)
+	app.Use(metricsMiddleware)
 	app.Use(cors.New(cors.Config{
-		AllowOrigins: "*",
-		AllowMethods: "GET,POST",
-		AllowHeaders: "Origin, Content-Type, Accept",
+		AllowOrigins: cfg.CORS.Origins(),
+		AllowMethods: "GET,POST,DELETE",
+		AllowHeaders: "Origin, Content-Type, Accept, Authorization, X-API-Key",
 	}))
+	app.Use(requireAPIKey)
 
 	// Routes
 	app.Get("/", handleRoot)
 	app.Get("/health", handleHealth)
+	app.Get("/health/live", handleHealth)
+	app.Get("/health/ready", handleReady)
+	app.Get("/metrics", handleMetrics)
 	app.Post("/ocr/extract", handleOCRExtract)
+	app.Post("/ocr/pdf", handleOCRPDF)
+	app.Post("/ocr/batch", handleOCRBatch)
+	app.Post("/ocr/jobs", handleJobSubmit)
+	app.Get("/ocr/jobs/:id", handleJobGet)
+	app.Delete("/ocr/jobs/:id", handleJobCancel)
+	app.Get("/ocr/jobs/:id/deliveries", handleJobDeliveries)
+	app.Get("/ocr/webhooks/dead-letters", handleDeadLetters)
+	app.Get("/ocr/stats", handleOCRStats)
+	app.Get("/admin/usage", handleAdminUsage)
 
 	// Start server
-	port := getEnv("PORT", "8080")
-	log.Printf("Server starting on port %s", port)
-	if err := app.Listen(":" + port); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
-	}
+	done := make(chan os.Signal, 1)
+	signal.Notify(done, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		log.Printf("Server starting on port %s", cfg.Port)
+		var err error
+		if cfg.UsesTLS() {
+			err = app.ListenTLS(cfg.Addr(), cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		} else {
+			err = app.Listen(cfg.Addr())
+		}
+		if err != nil {
+			log.Fatalf("Failed to start server: %v", err)
+		}
+	}()
+
+	// Blocks until SIGINT or SIGTERM
+	<-done
+	shutdown(app)
 }
 
 // handleRoot returns API information
 func handleRoot(c *fiber.Ctx) error {
 	return c.JSON(fiber.Map{
 		"name":        "Go Tesseract OCR API",
-		"version":     "1.0.0",
+		"version":     version,
 		"description": "Nepali text extraction API using Tesseract OCR",
 		"endpoints": fiber.Map{
-			"health": "GET /health",
-			"ocr":    "POST /ocr/extract",
+			"health":  "GET /health, /health/live, /health/ready",
+			"metrics": "GET /metrics",
+			"ocr":     "POST /ocr/extract",
+			"pdf":     "POST /ocr/pdf",
+			"batch":   "POST /ocr/batch",
+			"jobs":    "POST /ocr/jobs, GET|DELETE /ocr/jobs/{id}",
+			"stats":   "GET /ocr/stats",
+			"usage":   "GET /admin/usage",
 		},
 	})
 }
 
-// handleHealth returns health status
-func handleHealth(c *fiber.Ctx) error {
-	return c.JSON(HealthResponse{
-		Status:  "healthy",
-		Version: "1.0.0",
-		Time:    time.Now().Format(time.RFC3339),
-	})
-}
-
 // handleOCRExtract processes uploaded image and returns OCR results
 func handleOCRExtract(c *fiber.Ctx) error {
-	// Parse multipart form
+	file, err := readUpload(c)
+	if err != nil {
+		return err
+	}
+	config, err := formConfig(formValues(c))
+	if err != nil {
+		return err
+	}
+
+	format, err := responseFormat(c)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	layoutMode := c.FormValue("layout") == "true"
+	if layoutMode && format != ocr.FormatJSON {
+		return badRequest("Layout output is only available as JSON")
+	}
+	if format != ocr.FormatJSON {
+		// hOCR, ALTO and PAGE are written from the page tree down to the words
+		config.Granularity = ocr.GranularityWord
+	}
+	stream, err := streamMode(c)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	if stream != "" {
+		if layoutMode || format != ocr.FormatJSON {
+			return badRequest("Streaming is only available for plain JSON results, without layout or format")
+		}
+		return streamOCR(c, file, config, stream)
+	}
+	if layoutMode {
+		layoutConfig(config)
+	}
+
+	result, err := runOCR(c.UserContext(), file, config)
+	if err != nil {
+		return err
+	}
+
+	if layoutMode {
+		return c.JSON(analyzeLayout(result))
+	}
+
+	if format != ocr.FormatJSON {
+		var pages []*ocr.Page
+		switch r := result.(type) {
+		case *ocr.DocumentResult:
+			pages = r.PageTrees()
+		case *ocr.OCRResult:
+			pages = r.PageTrees()
+		}
+		var buf bytes.Buffer
+		if err := ocr.Export(&buf, format, pages, file.name); err != nil {
+			return &requestError{
+				Status:        fiber.StatusBadRequest,
+				ErrorResponse: ErrorResponse{Error: "export_failed", Message: err.Error()},
+			}
+		}
+		c.Set(fiber.HeaderContentType, format.ContentType())
+		return c.Send(buf.Bytes())
+	}
+
+	// Return JSON result
+	return c.JSON(result)
+}
+
+// handleOCRPDF runs OCR on the upload and returns a searchable PDF: the
+// page images with the recognized words as an invisible text layer
+func handleOCRPDF(c *fiber.Ctx) error {
+	file, err := readUpload(c)
+	if err != nil {
+		return err
+	}
+	config, err := formConfig(formValues(c))
+	if err != nil {
+		return err
+	}
+	// The text layer is placed word by word over the page images
+	config.Granularity = ocr.GranularityWord
+	config.KeepImage = true
+
+	result, err := runOCR(c.UserContext(), file, config)
+	if err != nil {
+		return err
+	}
+
+	var pages []ocr.PDFPage
+	switch r := result.(type) {
+	case *ocr.DocumentResult:
+		pages = r.PDFPages()
+	case *ocr.OCRResult:
+		pages = r.PDFPages()
+	}
+	var buf bytes.Buffer
+	if err := ocr.WriteSearchablePDF(&buf, pages, ocr.PDFOptions{FontPath: pdfFont, DPI: config.PDFDPI}); err != nil {
+		log.Printf("PDF generation error: %v", err)
+		return &requestError{
+			Status:        fiber.StatusInternalServerError,
+			ErrorResponse: ErrorResponse{Error: "pdf_failed", Message: "Failed to generate the searchable PDF"},
+		}
+	}
+
+	name := strings.TrimSuffix(file.name, file.ext) + ".pdf"
+	c.Set(fiber.HeaderContentType, "application/pdf")
+	c.Set(fiber.HeaderContentDisposition, mime.FormatMediaType("inline", map[string]string{"filename": name}))
+	return c.Send(buf.Bytes())
+}
+
+// requestError is answered by customErrorHandler with Status and an
+// ErrorResponse body
+type requestError struct {
+	Status int
+	ErrorResponse
+}
+
+func (e *requestError) Error() string {
+	return e.Message
+}
+
+// badRequest is a 400 for invalid form values
+func badRequest(message string) *requestError {
+	return &requestError{
+		Status:        fiber.StatusBadRequest,
+		ErrorResponse: ErrorResponse{Error: "bad_request", Message: message},
+	}
+}
+
+// upload is an image, TIFF or PDF file held in memory
+type upload struct {
+	name string
+	ext  string
+	data []byte
+	// format is sniffed from data, see ocr.DetectFormat
+	format string
+}
+
+// readUpload validates the "image" field and reads it into memory;
+// nothing is written to disk
+func readUpload(c *fiber.Ctx) (*upload, error) {
 	file, err := c.FormFile("image")
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
-			Error:   "bad_request",
-			Message: "No image file provided. Use 'image' field in multipart/form-data",
-		})
+		return nil, badRequest("No image file provided. Use 'image' field in multipart/form-data")
 	}
+	return openUpload(file)
+}
 
-	// Validate file size
-	if file.Size > maxUploadSize {
-		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
-			Error:   "file_too_large",
-			Message: fmt.Sprintf("File size exceeds maximum limit of %d MB", maxUploadSize/(1024*1024)),
-		})
+// openUpload checks the size of one uploaded file, reads it and checks its content
+func openUpload(file *multipart.FileHeader) (*upload, error) {
+	if err := checkSize(file.Size); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	data, err := readFormFile(file)
+	observeStage(stageUpload, time.Since(start))
+	if err != nil {
+		return nil, &requestError{
+			Status:        fiber.StatusInternalServerError,
+			ErrorResponse: ErrorResponse{Error: "upload_failed", Message: "Failed to read uploaded file"},
+		}
+	}
+	u := &upload{name: file.Filename, ext: filepath.Ext(file.Filename), data: data}
+	if err := inspectUpload(u); err != nil {
+		return nil, err
 	}
+	return u, nil
+}
 
-	// Validate file extension
-	ext := filepath.Ext(file.Filename)
-	if !isValidImageExtension(ext) {
-		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
-			Error:   "invalid_file_type",
-			Message: "Only .png, .jpg, .jpeg image files are supported",
-		})
+// routePath is path as the router matches it: without case or a trailing slash
+func routePath(path string) string {
+	if len(path) > 1 {
+		path = strings.TrimSuffix(path, "/")
 	}
+	return strings.ToLower(path)
+}
 
-	// Save uploaded file temporarily
-	timestamp := time.Now().UnixNano()
-	tempFilePath := filepath.Join(uploadDir, fmt.Sprintf("%d%s", timestamp, ext))
-	if err := c.SaveFile(file, tempFilePath); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
-			Error:   "upload_failed",
-			Message: "Failed to save uploaded file",
-		})
+// checkSize rejects files over limits.max_upload_mb before they are read
+func checkSize(size int64) error {
+	if size > cfg.MaxUploadSize() {
+		return &requestError{
+			Status: fiber.StatusBadRequest,
+			ErrorResponse: ErrorResponse{
+				Error:   "file_too_large",
+				Message: fmt.Sprintf("File size exceeds maximum limit of %d MB", cfg.Limits.MaxUploadMB),
+			},
+		}
 	}
-	defer os.Remove(tempFilePath) // Clean up after processing
+	return nil
+}
 
-	// Parse optional parameters
-	includeLines := c.FormValue("include_lines") == "true"
+// inspectUpload sniffs the format of an upload from its content, whatever
+// its name says, and checks the image header against the pixel limits
+// before the upload is queued for Tesseract
+func inspectUpload(file *upload) error {
+	info, err := ocr.Inspect(file.data, cfg.ImageLimits())
+	if err != nil {
+		return imageError(file.name, err)
+	}
+	file.format = info.Format
+	return nil
+}
+
+// imageError maps the errors of ocr.Inspect, which extraction also returns
+// for pages over the limits and pixels that do not decode
+func imageError(name string, err error) error {
+	switch {
+	case errors.Is(err, ocr.ErrUnsupportedFormat):
+		return &requestError{
+			Status: fiber.StatusUnsupportedMediaType,
+			ErrorResponse: ErrorResponse{
+				Error:   "unsupported_format",
+				Message: fmt.Sprintf("%s is not a PNG, JPEG, TIFF, WebP, BMP or GIF image or a PDF document", name),
+			},
+		}
+	case errors.Is(err, ocr.ErrImageTooLarge):
+		return &requestError{
+			Status: fiber.StatusRequestEntityTooLarge,
+			ErrorResponse: ErrorResponse{
+				Error: "image_too_large",
+				Message: fmt.Sprintf("%s: %v; images may be at most %d pixels wide or high and %d megapixels",
+					name, err, cfg.Limits.MaxImageSide, cfg.Limits.MaxMegapixels),
+			},
+		}
+	default:
+		return &requestError{
+			Status:        fiber.StatusBadRequest,
+			ErrorResponse: ErrorResponse{Error: "corrupt_image", Message: fmt.Sprintf("%s: %v", name, err)},
+		}
+	}
+}
+
+// formConfig builds the OCR configuration from the optional form fields.
+// Fields left out take their value from ocr.defaults of the config
+func formConfig(form url.Values) (*ocr.OCRConfig, error) {
+	form = withDefaults(form)
+	granularity, err := ocr.ParseGranularity(form.Get("granularity"))
+	if err != nil {
+		return nil, badRequest(err.Error())
+	}
+
+	dpi := ocr.DefaultPDFDPI
+	if v := form.Get("dpi"); v != "" {
+		dpi, err = strconv.Atoi(v)
+		if err != nil || dpi <= 0 {
+			return nil, badRequest("dpi must be a positive integer")
+		}
+	}
+	if dpi > cfg.Limits.MaxDPI {
+		return nil, badRequest(fmt.Sprintf("dpi may be at most %d", cfg.Limits.MaxDPI))
+	}
+
+	preprocess, err := ocr.ParsePreprocess(form.Get("preprocess"))
+	if err != nil {
+		return nil, badRequest(err.Error())
+	}
+
+	// Every "filter" field adds one text filter, in order
+	chain, err := textfilter.Parse(form["filter"])
+	if err != nil {
+		return nil, badRequest(err.Error())
+	}
+
+	languages, err := ocr.ParseLanguages(form.Get("lang"))
+	if err != nil {
+		return nil, badRequest(err.Error())
+	}
+
+	spell := form.Get("spell") == "true"
+	if spell && lexicon == nil {
+		return nil, badRequest("Spell correction is not available: the server has no lexicon (OCR_LEXICON)")
+	}
 
-	// Configure OCR
 	config := ocr.DefaultConfig()
-	config.IncludeLines = includeLines
+	config.Languages = languages
+	config.IncludeLines = form.Get("include_lines") == "true"
+	config.Granularity = granularity
+	config.PDFDPI = dpi
+	config.MaxPages = cfg.Limits.MaxPages
+	config.ImageLimits = cfg.ImageLimits()
+	config.VerifyImage = true
+	config.Preprocess = preprocess
+	config.DetectOrientation = form.Get("detect_orientation") == "true"
+	config.Filters = chain
+	config.NormalizeNepali = form.Get("normalize") == "true"
+	config.MinConfidence = cfg.OCR.Defaults.MinConfidence
+	config.PageSegMode = cfg.OCR.Defaults.PageSegMode
+	config.OnStage = observeStage
+	if spell {
+		config.Spell.Lexicon = lexicon
+	}
+	return config, nil
+}
+
+// withDefaults fills the form fields a request left out from ocr.defaults
+func withDefaults(form url.Values) url.Values {
+	merged := cfg.DefaultForm()
+	for key, values := range form {
+		merged[key] = values
+	}
+	return merged
+}
+
+// formValues returns the text fields of the multipart form
+func formValues(c *fiber.Ctx) url.Values {
+	form, err := c.MultipartForm()
+	if err != nil {
+		return url.Values{}
+	}
+	return form.Value
+}
+
+// runOCR extracts text from the upload within limits.ocr_timeout and turns
+// failures into error responses. Shutdown may cut it off
+func runOCR(ctx context.Context, file *upload, config *ocr.OCRConfig) (any, error) {
+	ctx, done := trackOCR(ctx, file.name)
+	defer done()
+	ctx, cancel := context.WithTimeout(ctx, cfg.Limits.OCRTimeout)
+	defer cancel()
 
-	// Perform OCR
-	result, err := ocr.ExtractFromImage(tempFilePath, config)
+	imageBytes.Observe(float64(len(file.data)))
+	result, err := extractUpload(ctx, file, config)
+	if err != nil && errors.Is(context.Cause(ctx), errShuttingDown) {
+		extractions.WithLabelValues("canceled").Inc()
+		log.Printf("OCR of %s canceled by shutdown", file.name)
+		return nil, &requestError{
+			Status:        fiber.StatusServiceUnavailable,
+			ErrorResponse: ErrorResponse{Error: "shutting_down", Message: "The server is shutting down, try again"},
+		}
+	}
+	if errors.Is(err, ocr.ErrLanguageNotInstalled) {
+		extractions.WithLabelValues("failed").Inc()
+		return nil, &requestError{
+			Status:        fiber.StatusBadRequest,
+			ErrorResponse: ErrorResponse{Error: "language_not_available", Message: err.Error()},
+		}
+	}
+	if errors.Is(err, ocr.ErrTooManyPools) {
+		extractions.WithLabelValues("failed").Inc()
+		return nil, &requestError{
+			Status:        fiber.StatusServiceUnavailable,
+			ErrorResponse: ErrorResponse{Error: "ocr_busy", Message: "Too many language combinations are in use, try again later"},
+		}
+	}
+	if errors.Is(err, ocr.ErrImageTooLarge) || errors.Is(err, ocr.ErrCorruptImage) {
+		extractions.WithLabelValues("failed").Inc()
+		return nil, imageError(file.name, err)
+	}
+	if errors.Is(err, ocr.ErrTooManyPages) {
+		extractions.WithLabelValues("failed").Inc()
+		return nil, &requestError{
+			Status:        fiber.StatusRequestEntityTooLarge,
+			ErrorResponse: ErrorResponse{Error: "too_many_pages", Message: err.Error()},
+		}
+	}
+	if ocr.IsTimeout(err) {
+		extractions.WithLabelValues("timeout").Inc()
+		log.Printf("OCR extraction timed out: %v", err)
+		return nil, &requestError{
+			Status:        fiber.StatusGatewayTimeout,
+			ErrorResponse: ErrorResponse{Error: "ocr_timeout", Message: fmt.Sprintf("OCR did not finish within %s", cfg.Limits.OCRTimeout)},
+		}
+	}
 	if err != nil {
+		extractions.WithLabelValues("failed").Inc()
 		log.Printf("OCR extraction error: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
-			Error:   "ocr_failed",
-			Message: "Failed to extract text from image",
-		})
+		return nil, &requestError{
+			Status:        fiber.StatusInternalServerError,
+			ErrorResponse: ErrorResponse{Error: "ocr_failed", Message: "Failed to extract text from image"},
+		}
 	}
+	extractions.WithLabelValues("ok").Inc()
+	observeResult(result)
+	return result, nil
+}
 
-	// Return JSON result
-	return c.JSON(result)
+// extractUpload dispatches on the sniffed format. PDFs and TIFFs produce one
+// result per page plus the combined text
+func extractUpload(ctx context.Context, file *upload, config *ocr.OCRConfig) (any, error) {
+	switch file.format {
+	case "pdf":
+		return engine.ExtractFromPDFBytes(ctx, file.data, config)
+	case "tiff":
+		return engine.ExtractFromTIFFBytes(ctx, file.data, config)
+	default:
+		return engine.ExtractFromBytes(ctx, file.data, config)
+	}
+}
+
+// layoutConfig asks for what layout analysis needs: line boxes, the page
+// size and the pixels of single images
+func layoutConfig(config *ocr.OCRConfig) {
+	config.IncludeLines = true
+	config.KeepPixels = true
+	if config.Granularity == ocr.GranularityNone {
+		config.Granularity = ocr.GranularityPage
+	}
+}
+
+// analyzeLayout turns an OCR result into its page layout. Single images
+// keep their pixels from recognition, so bold headlines can be told apart
+// by stroke width
+func analyzeLayout(result any) any {
+	switch r := result.(type) {
+	case *ocr.DocumentResult:
+		return layout.AnalyzeDocument(r, layout.Options{})
+	case *ocr.OCRResult:
+		return layout.AnalyzeResult(r, image.Rectangle{}, r.Pixels, layout.Options{})
+	}
+	return result
+}
+
+// acceptFormats maps the media types understood in the Accept header to
+// output formats
+var acceptFormats = map[string]ocr.Format{
+	fiber.MIMEApplicationJSON:        ocr.FormatJSON,
+	"application/xhtml+xml":          ocr.FormatHOCR,
+	"text/vnd.hocr+html":             ocr.FormatHOCR,
+	"application/alto+xml":           ocr.FormatALTO,
+	"application/vnd.prima.page+xml": ocr.FormatPAGE,
 }
 
-// isValidImageExtension checks if file extension is valid
-func isValidImageExtension(ext string) bool {
-	validExts := map[string]bool{
-		".png":  true,
-		".jpg":  true,
-		".jpeg": true,
-		".PNG":  true,
-		".JPG":  true,
-		".JPEG": true,
+// responseFormat picks the output format from the "format" form field, or
+// else from the Accept header. JSON is the default
+func responseFormat(c *fiber.Ctx) (ocr.Format, error) {
+	if v := c.FormValue("format"); v != "" {
+		return ocr.ParseFormat(v)
 	}
-	return validExts[ext]
+	// JSON is offered first, so it wins for */* and equal preferences
+	offer := c.Accepts(fiber.MIMEApplicationJSON, "application/xhtml+xml", "text/vnd.hocr+html",
+		"application/alto+xml", "application/vnd.prima.page+xml")
+	if format, ok := acceptFormats[offer]; ok {
+		return format, nil
+	}
+	return ocr.FormatJSON, nil
+}
+
+// handleOCRStats returns the size and queue depth of the Tesseract client pools
+func handleOCRStats(c *fiber.Ctx) error {
+	return c.JSON(engine.Stats())
+}
+
+// readFormFile returns the content of an uploaded multipart file
+func readFormFile(file *multipart.FileHeader) ([]byte, error) {
+	f, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
 }
 
 // customErrorHandler handles Fiber errors
 func customErrorHandler(c *fiber.Ctx, err error) error {
+	var re *requestError
+	if errors.As(err, &re) {
+		return c.Status(re.Status).JSON(re.ErrorResponse)
+	}
+
 	code := fiber.StatusInternalServerError
 	if e, ok := err.(*fiber.Error); ok {
 		code = e.Code
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/ToniBirat7/tesseract_ocr_ne/pkg/ocr"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics served on /metrics
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ocr_http_requests_total",
+		Help: "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ocr_http_request_duration_seconds",
+		Help:    "Time to answer HTTP requests, by route and method.",
+		Buckets: []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"route", "method"})
+
+	stageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ocr_stage_duration_seconds",
+		Help:    "Time spent in each stage of OCR: upload, preprocess, acquire, recognize and clean.",
+		Buckets: []float64{.001, .005, .01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"stage"})
+
+	extractions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ocr_extractions_total",
+		Help: "OCR runs by outcome: ok, timeout, failed or canceled (by shutdown).",
+	}, []string{"outcome"})
+
+	imageBytes = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "ocr_image_bytes",
+		Help:    "Size of the images and documents sent for OCR.",
+		Buckets: prometheus.ExponentialBuckets(16<<10, 2, 10), // 16KB to 8MB
+	})
+
+	pageConfidence = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "ocr_page_confidence",
+		Help:    "Average line confidence of every recognized page, from 0 to 100.",
+		Buckets: prometheus.LinearBuckets(10, 10, 9),
+	})
+)
+
+// stageUpload times reading an upload, before the stages of the ocr package
+const stageUpload ocr.Stage = "upload"
+
+func init() {
+	prometheus.MustRegister(poolCollector{})
+}
+
+// observeStage feeds OCRConfig.OnStage into stageDuration
+func observeStage(stage ocr.Stage, elapsed time.Duration) {
+	stageDuration.WithLabelValues(string(stage)).Observe(elapsed.Seconds())
+}
+
+// observeResult records the confidence of every page of a result
+func observeResult(result any) {
+	switch r := result.(type) {
+	case *ocr.DocumentResult:
+		for _, p := range r.Pages {
+			pageConfidence.Observe(p.AverageConfidence)
+		}
+	case *ocr.OCRResult:
+		pageConfidence.Observe(r.AverageConfidence)
+	}
+}
+
+// metricsMiddleware counts every request and its latency by route. Errors
+// are rendered here, not later by Fiber, so their status code is counted
+func metricsMiddleware(c *fiber.Ctx) error {
+	start := time.Now()
+	if err := c.Next(); err != nil {
+		if err := c.App().ErrorHandler(c, err); err != nil {
+			c.SendStatus(fiber.StatusInternalServerError)
+		}
+	}
+
+	// The route pattern, not the path, keeps job IDs out of the labels
+	route := c.Route().Path
+	httpRequests.WithLabelValues(route, c.Method(), strconv.Itoa(c.Response().StatusCode())).Inc()
+	httpDuration.WithLabelValues(route, c.Method()).Observe(time.Since(start).Seconds())
+	return nil
+}
+
+// handleMetrics serves the Prometheus text format
+var handleMetrics = adaptor.HTTPHandler(promhttp.Handler())
+
+// poolCollector reports the Tesseract client pools and the job queue at
+// scrape time
+type poolCollector struct{}
+
+var (
+	poolClientsDesc = prometheus.NewDesc("ocr_pool_clients",
+		"Tesseract clients of a pool by state: idle or in_use.",
+		[]string{"language", "psm", "state"}, nil)
+	poolWaitingDesc = prometheus.NewDesc("ocr_pool_waiting",
+		"Requests waiting for a Tesseract client of a pool.",
+		[]string{"language", "psm"}, nil)
+	poolCapacityDesc = prometheus.NewDesc("ocr_pool_capacity",
+		"Most Tesseract clients a pool may hold.",
+		[]string{"language", "psm"}, nil)
+	jobsDesc = prometheus.NewDesc("ocr_jobs",
+		"Asynchronous jobs by state: pending or running.",
+		[]string{"state"}, nil)
+)
+
+func (poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolClientsDesc
+	ch <- poolWaitingDesc
+	ch <- poolCapacityDesc
+	ch <- jobsDesc
+}
+
+func (poolCollector) Collect(ch chan<- prometheus.Metric) {
+	if engine != nil {
+		for _, p := range engine.Stats().Pools {
+			psm := strconv.Itoa(p.PageSegMode)
+			ch <- prometheus.MustNewConstMetric(poolClientsDesc, prometheus.GaugeValue, float64(p.Idle), p.Language, psm, "idle")
+			ch <- prometheus.MustNewConstMetric(poolClientsDesc, prometheus.GaugeValue, float64(p.InUse), p.Language, psm, "in_use")
+			ch <- prometheus.MustNewConstMetric(poolWaitingDesc, prometheus.GaugeValue, float64(p.Waiting), p.Language, psm)
+			ch <- prometheus.MustNewConstMetric(poolCapacityDesc, prometheus.GaugeValue, float64(p.Capacity), p.Language, psm)
+		}
+	}
+	if jobQueue != nil {
+		stats := jobQueue.Stats()
+		ch <- prometheus.MustNewConstMetric(jobsDesc, prometheus.GaugeValue, float64(stats.Pending), "pending")
+		ch <- prometheus.MustNewConstMetric(jobsDesc, prometheus.GaugeValue, float64(stats.Running), "running")
+	}
+}
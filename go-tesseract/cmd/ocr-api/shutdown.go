@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// cancelGrace is how long canceled OCR runs get to answer their requests
+// before the process exits
+const cancelGrace = 5 * time.Second
+
+// errShuttingDown is the cause of OCR runs canceled by shutdown
+var errShuttingDown = errors.New("server is shutting down")
+
+// inFlight tracks the OCR runs of requests, so shutdown can wait for them
+// and cancel those that outlast http_server.shutdown_timeout
+var inFlight = struct {
+	mu   sync.Mutex
+	next int
+	runs map[int]string
+	// drain ends when the runs still going are to be canceled
+	drain  context.Context
+	cutOff context.CancelFunc
+}{runs: make(map[int]string)}
+
+func init() {
+	inFlight.drain, inFlight.cutOff = context.WithCancel(context.Background())
+}
+
+// trackOCR registers an OCR run of file. The returned context is canceled
+// with errShuttingDown when shutdown cuts the run off; done must be called
+// when the run ends
+func trackOCR(ctx context.Context, file string) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	stop := context.AfterFunc(inFlight.drain, func() { cancel(errShuttingDown) })
+
+	inFlight.mu.Lock()
+	id := inFlight.next
+	inFlight.next++
+	inFlight.runs[id] = file
+	inFlight.mu.Unlock()
+
+	return ctx, func() {
+		stop()
+		cancel(nil)
+		inFlight.mu.Lock()
+		delete(inFlight.runs, id)
+		inFlight.mu.Unlock()
+	}
+}
+
+// runningOCR returns the files being recognized, sorted
+func runningOCR() []string {
+	inFlight.mu.Lock()
+	defer inFlight.mu.Unlock()
+	files := make([]string, 0, len(inFlight.runs))
+	for _, file := range inFlight.runs {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// cancelOCR cuts off every OCR run still going and logs which
+func cancelOCR() {
+	if files := runningOCR(); len(files) > 0 {
+		log.Printf("Canceling %d OCR runs still going: %s", len(files), strings.Join(files, ", "))
+	}
+	inFlight.cutOff()
+}
+
+// waitOCR waits until no OCR run is left or ctx ends
+func waitOCR(ctx context.Context) bool {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if len(runningOCR()) == 0 {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+}
+
+// shutdown stops taking requests and jobs and waits up to
+// http_server.shutdown_timeout for the running ones. Requests still running
+// then are canceled and answered with 503, jobs are queued again for the
+// next start, and the uploads left behind are removed
+func shutdown(app *fiber.App) {
+	log.Printf("Shutting down, waiting up to %s for running requests and jobs", cfg.ShutdownTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	// At the deadline the requests still running are cut off while the
+	// server waits for their connections
+	stop := context.AfterFunc(ctx, cancelOCR)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if err := app.ShutdownWithContext(ctx); err != nil {
+			log.Printf("Connections still open after %s: %v", cfg.ShutdownTimeout, err)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		if err := jobQueue.Close(ctx); err != nil {
+			log.Printf("Job queue: %v; they run again on the next start", err)
+		}
+	}()
+	wg.Wait()
+
+	if !stop() {
+		// The deadline passed: give the canceled runs a moment to answer
+		graceCtx, cancel := context.WithTimeout(context.Background(), cancelGrace)
+		defer cancel()
+		if !waitOCR(graceCtx) {
+			log.Printf("OCR runs still going at exit: %s", strings.Join(runningOCR(), ", "))
+		}
+	}
+
+	sweepUploads()
+	log.Printf("Server stopped")
+}
+
+// sweepUploads removes the files in the upload directory that belong to no
+// unfinished job
+func sweepUploads() {
+	removed, err := jobQueue.Sweep()
+	if err != nil {
+		log.Printf("Failed to sweep uploads: %v", err)
+	}
+	if len(removed) > 0 {
+		log.Printf("Removed %d orphaned upload files: %s", len(removed), strings.Join(removed, ", "))
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ToniBirat7/tesseract_ocr_ne/pkg/ocr"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Media types of the two streaming response modes
+const (
+	mimeEventStream = "text/event-stream"
+	mimeNDJSON      = "application/x-ndjson"
+)
+
+// heartbeatInterval is how often a stream writes to a client that is still
+// waiting for a page, to find out whether it has gone away
+const heartbeatInterval = 5 * time.Second
+
+// PageEvent announces a recognized page; its lines follow as LineEvents
+type PageEvent struct {
+	Page              int              `json:"page"`
+	PageCount         int              `json:"page_count"`
+	Text              string           `json:"text"`
+	AverageConfidence float64          `json:"average_confidence"`
+	LineCount         int              `json:"line_count"`
+	Orientation       *ocr.Orientation `json:"orientation,omitempty"`
+	// Tree is the page tree, sent when a granularity is asked for
+	Tree *ocr.Page `json:"tree,omitempty"`
+}
+
+// LineEvent is one recognized line with its box
+type LineEvent struct {
+	Page  int `json:"page"`
+	Index int `json:"index"`
+	ocr.ExtractedLine
+}
+
+// SummaryEvent ends a successful stream with the figures of the whole upload
+type SummaryEvent struct {
+	PageCount         int     `json:"page_count"`
+	Text              string  `json:"text"`
+	AverageConfidence float64 `json:"average_confidence"`
+	LineCount         int     `json:"line_count"`
+	DurationMS        int64   `json:"duration_ms"`
+}
+
+// streamMode picks the streaming response mode of /ocr/extract and
+// /ocr/batch from the "stream" form field ("sse", "ndjson" or "true" for
+// SSE), or else from the Accept header. The empty string means no streaming
+func streamMode(c *fiber.Ctx) (string, error) {
+	switch v := c.FormValue("stream"); v {
+	case "true", "sse":
+		return mimeEventStream, nil
+	case "ndjson":
+		return mimeNDJSON, nil
+	case "false":
+		return "", nil
+	case "":
+	default:
+		return "", fmt.Errorf("unknown stream mode %q (use sse or ndjson)", v)
+	}
+
+	// JSON is offered first, so a plain response wins for */*
+	switch offer := c.Accepts(fiber.MIMEApplicationJSON, mimeEventStream, mimeNDJSON); offer {
+	case mimeEventStream, mimeNDJSON:
+		return offer, nil
+	}
+	return "", nil
+}
+
+// eventWriter writes events as Server-Sent Events or as NDJSON lines of
+// {"event": ..., "data": ...}, flushing every event to the client
+type eventWriter struct {
+	mu  sync.Mutex
+	w   *bufio.Writer
+	sse bool
+}
+
+func (e *eventWriter) send(event string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.sse {
+		fmt.Fprintf(e.w, "event: %s\ndata: %s\n\n", event, payload)
+	} else {
+		line, err := json.Marshal(struct {
+			Event string          `json:"event"`
+			Data  json.RawMessage `json:"data"`
+		}{event, payload})
+		if err != nil {
+			return err
+		}
+		e.w.Write(line)
+		e.w.WriteByte('\n')
+	}
+	return e.w.Flush()
+}
+
+// heartbeat writes to the client every heartbeatInterval until ctx ends,
+// calling gone once a write fails. SSE gets a comment, which clients
+// ignore; NDJSON gets a {"event":"heartbeat"} line
+func (e *eventWriter) heartbeat(ctx context.Context, gone func()) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		e.mu.Lock()
+		if e.sse {
+			e.w.WriteString(": heartbeat\n\n")
+		} else {
+			e.w.WriteString(`{"event":"heartbeat"}` + "\n")
+		}
+		err := e.w.Flush()
+		e.mu.Unlock()
+		if err != nil {
+			gone()
+			return
+		}
+	}
+}
+
+// sendPage sends a page event followed by one event per line
+func (e *eventWriter) sendPage(number, count int, result *ocr.OCRResult) error {
+	err := e.send("page", PageEvent{
+		Page:              number,
+		PageCount:         count,
+		Text:              result.Text,
+		AverageConfidence: result.AverageConfidence,
+		LineCount:         result.LineCount,
+		Orientation:       result.Orientation,
+		Tree:              result.Page,
+	})
+	if err != nil {
+		return err
+	}
+	for i, line := range result.Lines {
+		if err := e.send("line", LineEvent{Page: number, Index: i, ExtractedLine: line}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setStreamHeaders starts a streaming response in mode
+func setStreamHeaders(c *fiber.Ctx, mode string) {
+	c.Set(fiber.HeaderContentType, mode)
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	// Keep reverse proxies such as nginx from buffering the stream
+	c.Set("X-Accel-Buffering", "no")
+}
+
+// streamOCR answers with a stream of events instead of one JSON body: every
+// page of a PDF or TIFF and its lines are sent as soon as it is recognized,
+// then a summary, or an error event if OCR fails. A client that goes away
+// cancels the OCR: the heartbeat finds out while a page is being recognized
+func streamOCR(c *fiber.Ctx, file *upload, config *ocr.OCRConfig, mode string) error {
+	// Lines carry the boxes the events are made of
+	config.IncludeLines = true
+
+	ctx, cancel := context.WithCancel(c.UserContext())
+	setStreamHeaders(c, mode)
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer cancel()
+		start := time.Now()
+		out := &eventWriter{w: w, sse: mode == mimeEventStream}
+
+		config.OnPage = func(number, count int, page *ocr.OCRResult) {
+			if err := out.sendPage(number, count, page); err != nil {
+				cancel()
+			}
+		}
+
+		beat, stop := context.WithCancel(ctx)
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			out.heartbeat(beat, cancel)
+		}()
+
+		result, err := runOCR(ctx, file, config)
+		stop()
+		wg.Wait()
+		if ctx.Err() != nil {
+			// The client is gone
+			return
+		}
+		if err != nil {
+			out.send("error", errorResponse(err))
+			return
+		}
+
+		summary := SummaryEvent{PageCount: 1}
+		switch r := result.(type) {
+		case *ocr.DocumentResult:
+			summary.PageCount = r.PageCount
+			summary.Text = r.Text
+			summary.AverageConfidence = r.AverageConfidence
+			summary.LineCount = r.LineCount
+		case *ocr.OCRResult:
+			if out.sendPage(1, 1, r) != nil {
+				return
+			}
+			summary.Text = r.Text
+			summary.AverageConfidence = r.AverageConfidence
+			summary.LineCount = r.LineCount
+		}
+		summary.DurationMS = time.Since(start).Milliseconds()
+		out.send("summary", summary)
+	})
+	return nil
+}
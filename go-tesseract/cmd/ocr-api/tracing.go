@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the server span of every request; the ocr package adds
+// its extraction and stage spans below it
+var tracer = otel.Tracer("github.com/ToniBirat7/tesseract_ocr_ne/cmd/ocr-api")
+
+// setupTracing exports spans over OTLP/HTTP when OTEL_EXPORTER_OTLP_ENDPOINT
+// (or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT) is set, e.g. to
+// http://localhost:4318 for a local collector. The returned function
+// flushes the spans still buffered
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+	if getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", getEnv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")) == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	// The exporter reads its endpoint, headers and protocol from the
+	// standard OTEL_EXPORTER_OTLP_* variables
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the name
+	res, err := resource.Merge(
+		resource.NewSchemaless(attribute.String("service.name", "ocr-api")),
+		resource.Default(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	log.Printf("Exporting traces over OTLP")
+	return provider.Shutdown, nil
+}
+
+// tracingMiddleware opens a server span per request, continuing the trace
+// of the caller when it sent a traceparent header, and hands it on in the
+// user context
+func tracingMiddleware(c *fiber.Ctx) error {
+	carrier := propagation.MapCarrier{}
+	for _, h := range []string{"traceparent", "tracestate", "baggage"} {
+		if v := c.Get(h); v != "" {
+			carrier[h] = v
+		}
+	}
+	ctx := otel.GetTextMapPropagator().Extract(c.UserContext(), carrier)
+
+	ctx, span := tracer.Start(ctx, c.Method()+" "+c.Path(), trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+	c.SetUserContext(ctx)
+
+	err := c.Next()
+	// Routing is done now, so the span can be named after the route pattern
+	span.SetName(c.Method() + " " + c.Route().Path)
+	status := c.Response().StatusCode()
+	span.SetAttributes(
+		attribute.String("http.method", c.Method()),
+		attribute.String("http.route", c.Route().Path),
+		attribute.Int("http.status_code", status),
+	)
+	if err != nil || status >= fiber.StatusInternalServerError {
+		span.SetStatus(codes.Error, "")
+	}
+	return err
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/url"
+	"path/filepath"
+	"slices"
+
+	"github.com/ToniBirat7/tesseract_ocr_ne/pkg/jobs"
+	"github.com/ToniBirat7/tesseract_ocr_ne/pkg/webhook"
+	"github.com/gofiber/fiber/v2"
+)
+
+// webhooks POSTs finished jobs to their callback_url; nil when
+// webhooks.secret is not set
+var webhooks *webhook.Dispatcher
+
+// openWebhooks starts the dispatcher with its delivery log in dataDir.
+// Without a secret callbacks are disabled, since receivers could not tell
+// our requests from forged ones
+func openWebhooks(dataDir string) (*webhook.Dispatcher, *webhook.Store, error) {
+	secret := cfg.Webhooks.Secret
+	if secret == "" {
+		return nil, nil, nil
+	}
+
+	store, err := webhook.OpenStore(filepath.Join(dataDir, "webhooks.db"))
+	if err != nil {
+		return nil, nil, err
+	}
+	dispatcher, err := webhook.New(store, webhook.Options{
+		Secret:       secret,
+		MaxAttempts:  cfg.Webhooks.MaxAttempts,
+		AllowPrivate: cfg.Webhooks.AllowPrivate,
+		// The log of a job goes with the job
+		Retention: cfg.Jobs.Retention,
+	})
+	if err != nil {
+		store.Close()
+		return nil, nil, err
+	}
+	return dispatcher, store, nil
+}
+
+// callbackURL validates the optional callback_url form field
+func callbackURL(form url.Values) error {
+	raw := form.Get("callback_url")
+	if raw == "" {
+		return nil
+	}
+	if webhooks == nil {
+		return badRequest("Callbacks are not available: the server has no webhook secret (OCR_WEBHOOK_SECRET)")
+	}
+	if err := webhooks.ValidateURL(raw); err != nil {
+		return badRequest(err.Error())
+	}
+	return nil
+}
+
+// notifyJob queues the callback of a job that succeeded or failed
+func notifyJob(job *jobs.Job) {
+	target := url.Values(job.Params).Get("callback_url")
+	if target == "" || webhooks == nil || job.Status == jobs.StatusCanceled {
+		return
+	}
+
+	payload, err := json.Marshal(job)
+	if err != nil {
+		log.Printf("job %s: failed to encode callback: %v", job.ID, err)
+		return
+	}
+	if _, err := webhooks.Enqueue(job.ID, job.Owner, target, "job."+string(job.Status), payload); err != nil {
+		log.Printf("job %s: %v", job.ID, err)
+	}
+}
+
+// handleJobDeliveries returns the callback delivery log of a job
+func handleJobDeliveries(c *fiber.Ctx) error {
+	job, err := callerJob(c)
+	if err != nil {
+		return jobError(err)
+	}
+	if webhooks == nil {
+		return c.JSON([]*webhook.Delivery{})
+	}
+	deliveries, err := webhooks.Deliveries(job.ID)
+	if err != nil {
+		return deliveryError(err)
+	}
+	return c.JSON(withoutPayloads(deliveries))
+}
+
+// handleDeadLetters returns the callbacks of the caller's jobs that were
+// given up on; admin keys see all of them
+func handleDeadLetters(c *fiber.Ctx) error {
+	if webhooks == nil {
+		return c.JSON([]*webhook.Delivery{})
+	}
+	deliveries, err := webhooks.DeadLetters()
+	if err != nil {
+		return deliveryError(err)
+	}
+	deliveries = slices.DeleteFunc(deliveries, func(d *webhook.Delivery) bool {
+		return !canAccess(c, d.Owner)
+	})
+	return c.JSON(withoutPayloads(deliveries))
+}
+
+// withoutPayloads drops the request bodies, which repeat the job result
+func withoutPayloads(deliveries []*webhook.Delivery) []*webhook.Delivery {
+	for _, d := range deliveries {
+		d.Payload = nil
+	}
+	if deliveries == nil {
+		deliveries = []*webhook.Delivery{}
+	}
+	return deliveries
+}
+
+func deliveryError(err error) error {
+	log.Printf("Delivery log error: %v", err)
+	return &requestError{
+		Status:        fiber.StatusInternalServerError,
+		ErrorResponse: ErrorResponse{Error: "internal_error", Message: "Failed to read the delivery log"},
+	}
+}
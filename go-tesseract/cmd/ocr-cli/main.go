@@ -1,21 +1,53 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"image"
 	"os"
 	"path/filepath"
+	"strings"
 
+	"github.com/ToniBirat7/tesseract_ocr_ne/pkg/layout"
 	"github.com/ToniBirat7/tesseract_ocr_ne/pkg/ocr"
+	"github.com/ToniBirat7/tesseract_ocr_ne/pkg/textfilter"
 )
 
+// stringList collects the values of a flag given more than once
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
 func main() {
 	// Command-line flags
-	imagePath := flag.String("image", "", "Path to image file (required)")
-	outputPath := flag.String("output", "", "Path to output JSON file (optional, prints to stdout if not specified)")
+	imagePath := flag.String("image", "", "Path to image, multi-page TIFF or PDF file (required)")
+	outputPath := flag.String("output", "", "Path to output file (optional, prints to stdout if not specified)")
+	format := flag.String("format", "json", "Output format: json, hocr, alto or page (PAGE XML writes one file per page)")
+	lang := flag.String("lang", "nep", "Tesseract languages, e.g. nep or nep+eng for mixed Nepali/English pages")
 	includeLines := flag.Bool("lines", false, "Include individual lines in output")
 	minConfidence := flag.Float64("min-confidence", 0.0, "Minimum confidence threshold (0-100)")
+	granularity := flag.String("granularity", "", "Include the page tree down to this level: page, block, paragraph, line or word")
+	dpi := flag.Int("dpi", ocr.DefaultPDFDPI, "Resolution to rasterize PDF pages at, also the page size of images in -pdf-out")
+	timeout := flag.Duration("timeout", 0, "Abort OCR after this long, e.g. 90s (0 disables)")
+	preprocess := flag.String("preprocess", "", "Comma separated preprocessing steps: grayscale, border, denoise, deskew, dpi=N, otsu, sauvola")
+	detectOrientation := flag.Bool("detect-orientation", false, "Detect pages rotated by 90/180/270 degrees and turn them upright")
+	var filters stringList
+	flag.Var(&filters, "filter", "Text filter to apply, in order; repeat for a chain (e.g. -filter nfc -filter devanagari -filter digits:ascii)")
+	normalize := flag.Bool("normalize", false, "Normalize Nepali Unicode and fix common OCR confusions (danda, nukta, matras)")
+	lexiconPath := flag.String("lexicon", "", "Correct words against this wordlist (one word per line, optional frequency)")
+	layoutMode := flag.Bool("layout", false, "Output the page layout (columns, reading order, headlines and articles) instead of plain results")
+	debugDir := flag.String("debug-dir", "", "Write the image after every preprocessing step to this directory")
+	pdfOut := flag.String("pdf-out", "", "Write a searchable PDF (page images with an invisible text layer) to this path; results go to -output only")
+	pdfFont := flag.String("pdf-font", ocr.DefaultPDFFont, "TrueType font with Devanagari embedded in the -pdf-out text layer")
 	flag.Parse()
 
 	// Validate input
@@ -32,17 +64,128 @@ func main() {
 	}
 
 	// Configure OCR
+	level, err := ocr.ParseGranularity(*granularity)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	steps, err := ocr.ParsePreprocess(*preprocess)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	steps.DebugDir = *debugDir
+	if *debugDir != "" && !steps.Enabled() {
+		// Dumping the intermediate images alone still shows what Tesseract gets
+		steps.Grayscale = true
+	}
+
+	chain, err := textfilter.Parse(filters)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	outFormat, err := ocr.ParseFormat(*format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if outFormat != ocr.FormatJSON && *layoutMode {
+		fmt.Fprintln(os.Stderr, "Error: -layout output is JSON only")
+		os.Exit(1)
+	}
+
+	languages, err := ocr.ParseLanguages(*lang)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	var lexicon *ocr.Lexicon
+	if *lexiconPath != "" {
+		lexicon, err = ocr.LoadLexicon(*lexiconPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	config := ocr.DefaultConfig()
+	config.Languages = languages
 	config.IncludeLines = *includeLines
 	config.MinConfidence = *minConfidence
+	config.Granularity = level
+	config.PDFDPI = *dpi
+	config.Preprocess = steps
+	config.DetectOrientation = *detectOrientation
+	config.Filters = chain
+	config.NormalizeNepali = *normalize
+	config.Spell.Lexicon = lexicon
+	if *layoutMode {
+		// Layout analysis needs line boxes, the page size and the pixels
+		config.IncludeLines = true
+		config.KeepPixels = true
+		if config.Granularity == ocr.GranularityNone {
+			config.Granularity = ocr.GranularityPage
+		}
+	}
+	if outFormat != ocr.FormatJSON || *pdfOut != "" {
+		// hOCR, ALTO, PAGE and the PDF text layer are written from the page
+		// tree down to the words
+		config.Granularity = ocr.GranularityWord
+	}
+	config.KeepImage = *pdfOut != ""
+
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
 
-	// Perform OCR
-	result, err := ocr.ExtractFromImage(*imagePath, config)
+	engine := ocr.NewEngine(ocr.EngineOptions{PoolSize: 1})
+	defer engine.Close()
+
+	// Perform OCR. PDFs and TIFFs produce one result per page plus the combined text
+	var result any
+	switch strings.ToLower(filepath.Ext(*imagePath)) {
+	case ".pdf":
+		result, err = engine.ExtractFromPDF(ctx, *imagePath, config)
+	case ".tif", ".tiff":
+		result, err = engine.ExtractFromTIFF(ctx, *imagePath, config)
+	default:
+		result, err = engine.ExtractFromImage(ctx, *imagePath, config)
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
 
+	if *pdfOut != "" {
+		if err := writePDF(result, *pdfOut, ocr.PDFOptions{FontPath: *pdfFont, DPI: *dpi}); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Searchable PDF saved to: %s\n", *pdfOut)
+		if *outputPath == "" {
+			return
+		}
+	}
+
+	if *layoutMode {
+		result = analyzeLayout(result)
+	}
+
+	if outFormat != ocr.FormatJSON {
+		if err := exportResult(result, outFormat, *imagePath, *outputPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Marshal to JSON
 	jsonData, err := json.MarshalIndent(result, "", "  ")
 	if err != nil {
@@ -70,3 +213,96 @@ func main() {
 		fmt.Println(string(jsonData))
 	}
 }
+
+// analyzeLayout turns an OCR result into its page layout. Single images
+// keep their pixels from recognition, so bold headlines can be told apart
+// by stroke width
+func analyzeLayout(result any) any {
+	switch r := result.(type) {
+	case *ocr.DocumentResult:
+		return layout.AnalyzeDocument(r, layout.Options{})
+	case *ocr.OCRResult:
+		return layout.AnalyzeResult(r, image.Rectangle{}, r.Pixels, layout.Options{})
+	}
+	return result
+}
+
+// exportResult writes the result as hOCR, ALTO or PAGE XML to outputPath,
+// or stdout when empty. PAGE XML holds a single page, so documents are
+// written to one numbered file per page next to outputPath
+func exportResult(result any, format ocr.Format, imagePath, outputPath string) error {
+	var pages []*ocr.Page
+	switch r := result.(type) {
+	case *ocr.DocumentResult:
+		pages = r.PageTrees()
+	case *ocr.OCRResult:
+		pages = r.PageTrees()
+	}
+	imageName := filepath.Base(imagePath)
+
+	if outputPath == "" {
+		return ocr.Export(os.Stdout, format, pages, imageName)
+	}
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return fmt.Errorf("creating output directory: %w", err)
+	}
+
+	if format == ocr.FormatPAGE && len(pages) > 1 {
+		ext := filepath.Ext(outputPath)
+		base := strings.TrimSuffix(outputPath, ext)
+		if ext == "" {
+			ext = format.Extension()
+		}
+		for i, page := range pages {
+			path := fmt.Sprintf("%s-%03d%s", base, i+1, ext)
+			if err := writeExport(path, format, []*ocr.Page{page}, imageName); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("Results saved to: %s-*%s (%d pages)\n", base, ext, len(pages))
+		return nil
+	}
+
+	if err := writeExport(outputPath, format, pages, imageName); err != nil {
+		return err
+	}
+	fmt.Printf("Results saved to: %s\n", outputPath)
+	return nil
+}
+
+// writeExport creates path and exports pages into it
+func writeExport(path string, format ocr.Format, pages []*ocr.Page, imageName string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("writing output file: %w", err)
+	}
+	if err := ocr.Export(f, format, pages, imageName); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writePDF writes the pages of result with their images as a searchable PDF
+func writePDF(result any, path string, opts ocr.PDFOptions) error {
+	var pages []ocr.PDFPage
+	switch r := result.(type) {
+	case *ocr.DocumentResult:
+		pages = r.PDFPages()
+	case *ocr.OCRResult:
+		pages = r.PDFPages()
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("creating output directory: %w", err)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("writing pdf: %w", err)
+	}
+	if err := ocr.WriteSearchablePDF(f, pages, opts); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,298 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ToniBirat7/tesseract_ocr_ne/pkg/ocr"
+	"github.com/ToniBirat7/tesseract_ocr_ne/pkg/textfilter"
+	"github.com/ilyakaznacheev/cleanenv"
+	"gopkg.in/yaml.v3"
+)
+
+type TLS struct {
+	CertFile string `yaml:"cert_file" env:"OCR_TLS_CERT"`
+	KeyFile  string `yaml:"key_file" env:"OCR_TLS_KEY"`
+}
+
+type HTTPServer struct {
+	Port         string        `yaml:"port" env:"PORT" env-default:"8080"`
+	ReadTimeout  time.Duration `yaml:"read_timeout" env:"OCR_READ_TIMEOUT" env-default:"1m"`
+	WriteTimeout time.Duration `yaml:"write_timeout" env:"OCR_WRITE_TIMEOUT" env-default:"5m"`
+	IdleTimeout  time.Duration `yaml:"idle_timeout" env:"OCR_IDLE_TIMEOUT" env-default:"2m"`
+	// ShutdownTimeout is how long running requests and jobs may finish
+	// after SIGTERM before they are canceled
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"OCR_SHUTDOWN_TIMEOUT" env-default:"30s"`
+	TLS             TLS           `yaml:"tls"`
+}
+
+type Limits struct {
+	// MaxUploadMB caps one file, also inside batches and ZIP archives
+	MaxUploadMB int `yaml:"max_upload_mb" env:"OCR_MAX_UPLOAD_MB" env-default:"10"`
+	// MaxBatchMB caps a whole batch request, i.e. all its files together.
+	// Other requests are held to MaxUploadMB plus room for form fields
+	MaxBatchMB    int           `yaml:"max_batch_mb" env:"OCR_MAX_BATCH_MB" env-default:"100"`
+	MaxBatchFiles int           `yaml:"max_batch_files" env:"OCR_MAX_BATCH_FILES" env-default:"200"`
+	OCRTimeout    time.Duration `yaml:"ocr_timeout" env:"OCR_TIMEOUT" env-default:"2m"`
+	// MaxImageSide and MaxMegapixels bound the pixels of an image or TIFF
+	// page, read from its header before anything is decoded
+	MaxImageSide  int `yaml:"max_image_side" env:"OCR_MAX_IMAGE_SIDE" env-default:"20000"`
+	MaxMegapixels int `yaml:"max_megapixels" env:"OCR_MAX_MEGAPIXELS" env-default:"100"`
+	// MaxPages bounds the pages of a PDF or TIFF, MaxDPI the resolution
+	// PDF pages are rasterized at
+	MaxPages int `yaml:"max_pages" env:"OCR_MAX_PAGES" env-default:"100"`
+	MaxDPI   int `yaml:"max_dpi" env:"OCR_MAX_DPI" env-default:"600"`
+	// MinFreeMB is the free disk space /health/ready asks for
+	MinFreeMB uint64 `yaml:"min_free_mb" env:"OCR_MIN_FREE_MB" env-default:"500"`
+}
+
+// OCRDefaults are the form fields of /ocr/extract used when a request
+// leaves them out, in the same words as the form
+type OCRDefaults struct {
+	Lang              string   `yaml:"lang" env:"OCR_DEFAULT_LANG" env-default:"nep"`
+	Granularity       string   `yaml:"granularity" env:"OCR_DEFAULT_GRANULARITY"`
+	DPI               int      `yaml:"dpi" env:"OCR_DEFAULT_DPI" env-default:"300"`
+	Preprocess        string   `yaml:"preprocess" env:"OCR_DEFAULT_PREPROCESS"`
+	Filters           []string `yaml:"filters" env:"OCR_DEFAULT_FILTERS"`
+	IncludeLines      bool     `yaml:"include_lines" env:"OCR_DEFAULT_INCLUDE_LINES"`
+	DetectOrientation bool     `yaml:"detect_orientation" env:"OCR_DEFAULT_DETECT_ORIENTATION"`
+	Normalize         bool     `yaml:"normalize" env:"OCR_DEFAULT_NORMALIZE"`
+	// MinConfidence and PageSegMode have no form field; they apply to every request
+	MinConfidence float64 `yaml:"min_confidence" env:"OCR_MIN_CONFIDENCE"`
+	PageSegMode   int     `yaml:"page_seg_mode" env:"OCR_PAGE_SEG_MODE"`
+}
+
+type OCR struct {
+	// Languages must load for /health/ready, e.g. [nep, nep+eng]
+	Languages []string `yaml:"languages" env:"OCR_LANGUAGES" env-default:"nep"`
+	PoolSize  int      `yaml:"pool_size" env:"OCR_POOL_SIZE"`
+	// MaxPools caps the language/PSM pairs with warm clients; pools unused
+	// for PoolIdleTimeout are dropped
+	MaxPools        int           `yaml:"max_pools" env:"OCR_MAX_POOLS" env-default:"8"`
+	PoolIdleTimeout time.Duration `yaml:"pool_idle_timeout" env:"OCR_POOL_IDLE_TIMEOUT" env-default:"10m"`
+	BatchWorkers    int           `yaml:"batch_workers" env:"OCR_BATCH_WORKERS" env-default:"4"`
+	Lexicon         string        `yaml:"lexicon" env:"OCR_LEXICON"`
+	PDFFont         string        `yaml:"pdf_font" env:"OCR_PDF_FONT"`
+	Defaults        OCRDefaults   `yaml:"defaults"`
+}
+
+type Jobs struct {
+	Workers  int           `yaml:"workers" env:"OCR_JOB_WORKERS" env-default:"2"`
+	MaxQueue int           `yaml:"max_queue" env:"OCR_JOB_QUEUE" env-default:"100"`
+	Timeout  time.Duration `yaml:"timeout" env:"OCR_JOB_TIMEOUT" env-default:"30m"`
+	// Retention is how long finished jobs, their results and their
+	// finished webhook deliveries are kept
+	Retention time.Duration `yaml:"retention" env:"OCR_JOB_RETENTION" env-default:"168h"`
+}
+
+type Webhooks struct {
+	Secret      string `yaml:"secret" env:"OCR_WEBHOOK_SECRET"`
+	MaxAttempts int    `yaml:"max_attempts" env:"OCR_WEBHOOK_MAX_ATTEMPTS" env-default:"5"`
+	// AllowPrivate lets callbacks reach loopback, private and link-local
+	// addresses, which are refused by default
+	AllowPrivate bool `yaml:"allow_private" env:"OCR_WEBHOOK_ALLOW_PRIVATE"`
+}
+
+type CORS struct {
+	AllowOrigins []string `yaml:"allow_origins" env:"OCR_CORS_ORIGINS" env-default:"*"`
+}
+
+type Config struct {
+	DataDir    string `yaml:"data_dir" env:"OCR_DATA_DIR" env-default:"data"`
+	APIKeys    string `yaml:"api_keys" env:"OCR_API_KEYS"`
+	HTTPServer `yaml:"http_server"`
+	Limits     Limits   `yaml:"limits"`
+	OCR        OCR      `yaml:"ocr"`
+	Jobs       Jobs     `yaml:"jobs"`
+	Webhooks   Webhooks `yaml:"webhooks"`
+	CORS       CORS     `yaml:"cors"`
+}
+
+// MustLoad reads the config file named by CONFIG_PATH or -config, with
+// environment variables taking precedence over it. Without a file the
+// settings come from the environment and defaults alone. With
+// -print-config the result is printed as YAML and the program exits
+func MustLoad() *Config {
+	configPath := os.Getenv("CONFIG_PATH")
+
+	flags := flag.String("config", "", "path to the config file")
+	printConfig := flag.Bool("print-config", false, "print the effective configuration and exit")
+	flag.Parse()
+	if configPath == "" {
+		configPath = *flags
+	}
+
+	var cfg Config
+	if configPath != "" {
+		if _, err := os.Stat(configPath); os.IsNotExist(err) {
+			log.Fatalf("Config file does not exist: %s", configPath)
+		}
+		if err := cleanenv.ReadConfig(configPath, &cfg); err != nil {
+			log.Fatalf("cannot read config file: %s", err.Error())
+		}
+	} else if err := cleanenv.ReadEnv(&cfg); err != nil {
+		log.Fatalf("cannot read config: %s", err.Error())
+	}
+
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("invalid config: %s", err.Error())
+	}
+
+	if *printConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
+	return &cfg
+}
+
+// Validate checks every setting, so mistakes stop the server at startup
+// instead of failing requests
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	port, err := strconv.Atoi(c.Port)
+	check(err == nil && port > 0 && port < 65536, "http_server.port: %q is not a port number", c.Port)
+	check(c.ReadTimeout > 0 && c.WriteTimeout > 0 && c.IdleTimeout > 0 && c.ShutdownTimeout > 0, "http_server: timeouts must be positive")
+	check((c.TLS.CertFile == "") == (c.TLS.KeyFile == ""), "http_server.tls: set both cert_file and key_file, or neither")
+	for _, f := range []string{c.TLS.CertFile, c.TLS.KeyFile, c.OCR.Lexicon, c.APIKeys} {
+		if f != "" {
+			_, err := os.Stat(f)
+			check(err == nil, "%v", err)
+		}
+	}
+
+	check(c.Limits.MaxUploadMB > 0, "limits.max_upload_mb must be positive")
+	check(c.Limits.MaxBatchMB >= c.Limits.MaxUploadMB, "limits.max_batch_mb must be at least max_upload_mb")
+	check(c.Limits.MaxBatchFiles > 0, "limits.max_batch_files must be positive")
+	check(c.Limits.OCRTimeout > 0, "limits.ocr_timeout must be positive")
+	check(c.Limits.MaxImageSide > 0, "limits.max_image_side must be positive")
+	check(c.Limits.MaxMegapixels > 0, "limits.max_megapixels must be positive")
+	check(c.Limits.MaxPages > 0, "limits.max_pages must be positive")
+	check(c.Limits.MaxDPI > 0 && c.Limits.MaxDPI <= ocr.MaxPDFDPI, "limits.max_dpi must be between 1 and %d", ocr.MaxPDFDPI)
+
+	check(len(c.OCR.Languages) > 0, "ocr.languages must list at least one language")
+	for _, lang := range c.OCR.Languages {
+		_, err := ocr.ParseLanguages(lang)
+		check(err == nil, "ocr.languages: %v", err)
+	}
+	check(c.OCR.PoolSize >= 0, "ocr.pool_size must not be negative")
+	check(c.OCR.MaxPools > 0, "ocr.max_pools must be positive")
+	check(c.OCR.PoolIdleTimeout > 0, "ocr.pool_idle_timeout must be positive")
+	check(c.OCR.BatchWorkers > 0, "ocr.batch_workers must be positive")
+	if c.OCR.PDFFont != "" {
+		_, err := os.Stat(c.OCR.PDFFont)
+		check(err == nil, "ocr.pdf_font: %v", err)
+	}
+
+	d := c.OCR.Defaults
+	_, err = ocr.ParseLanguages(d.Lang)
+	check(err == nil, "ocr.defaults.lang: %v", err)
+	_, err = ocr.ParseGranularity(d.Granularity)
+	check(err == nil, "ocr.defaults.granularity: %v", err)
+	check(d.DPI > 0 && d.DPI <= c.Limits.MaxDPI, "ocr.defaults.dpi must be between 1 and limits.max_dpi")
+	_, err = ocr.ParsePreprocess(d.Preprocess)
+	check(err == nil, "ocr.defaults.preprocess: %v", err)
+	_, err = textfilter.Parse(d.Filters)
+	check(err == nil, "ocr.defaults.filters: %v", err)
+	check(d.MinConfidence >= 0 && d.MinConfidence <= 100, "ocr.defaults.min_confidence must be between 0 and 100")
+	check(d.PageSegMode >= 0 && d.PageSegMode <= 13, "ocr.defaults.page_seg_mode must be between 0 and 13")
+
+	check(c.Jobs.Workers > 0, "jobs.workers must be positive")
+	check(c.Jobs.MaxQueue >= 0, "jobs.max_queue must not be negative")
+	check(c.Jobs.Timeout >= 0, "jobs.timeout must not be negative")
+	check(c.Jobs.Retention > 0, "jobs.retention must be positive")
+	check(c.Webhooks.MaxAttempts > 0, "webhooks.max_attempts must be positive")
+	check(len(c.CORS.AllowOrigins) > 0, "cors.allow_origins must not be empty")
+
+	return errors.Join(errs...)
+}
+
+// MaxUploadSize is Limits.MaxUploadMB in bytes
+func (c *Config) MaxUploadSize() int64 {
+	return int64(c.Limits.MaxUploadMB) << 20
+}
+
+// formFieldsSize is the room left beside the file for the other form fields
+const formFieldsSize = 1 << 20
+
+// MaxRequestSize is the body limit of every request but a batch: one
+// upload and its form fields
+func (c *Config) MaxRequestSize() int {
+	return int(c.MaxUploadSize()) + formFieldsSize
+}
+
+// MaxBatchSize is Limits.MaxBatchMB in bytes
+func (c *Config) MaxBatchSize() int {
+	return c.Limits.MaxBatchMB << 20
+}
+
+// ImageLimits are the pixel limits of Limits for ocr.Inspect
+func (c *Config) ImageLimits() ocr.ImageLimits {
+	return ocr.ImageLimits{MaxSide: c.Limits.MaxImageSide, MaxPixels: c.Limits.MaxMegapixels * 1_000_000}
+}
+
+// DefaultForm returns OCR.Defaults as the form values of /ocr/extract
+func (c *Config) DefaultForm() url.Values {
+	d := c.OCR.Defaults
+	form := url.Values{}
+	set := func(key, value string) {
+		if value != "" {
+			form.Set(key, value)
+		}
+	}
+	set("lang", d.Lang)
+	set("granularity", d.Granularity)
+	set("dpi", strconv.Itoa(d.DPI))
+	set("preprocess", d.Preprocess)
+	set("include_lines", strconv.FormatBool(d.IncludeLines))
+	set("detect_orientation", strconv.FormatBool(d.DetectOrientation))
+	set("normalize", strconv.FormatBool(d.Normalize))
+	if len(d.Filters) > 0 {
+		form["filter"] = d.Filters
+	}
+	return form
+}
+
+// Print writes the configuration as YAML with secrets masked
+func (c *Config) Print(w io.Writer) error {
+	masked := *c
+	if masked.Webhooks.Secret != "" {
+		masked.Webhooks.Secret = "********"
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(masked); err != nil {
+		return fmt.Errorf("failed to print config: %w", err)
+	}
+	return enc.Close()
+}
+
+// Addr is the listen address of the server
+func (h HTTPServer) Addr() string {
+	return ":" + h.Port
+}
+
+// UsesTLS reports whether the server serves HTTPS
+func (h HTTPServer) UsesTLS() bool {
+	return h.TLS.CertFile != ""
+}
+
+// Origins is CORS.AllowOrigins in the comma separated form Fiber expects
+func (c CORS) Origins() string {
+	return strings.Join(c.AllowOrigins, ",")
+}
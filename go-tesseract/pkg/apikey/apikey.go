@@ -0,0 +1,103 @@
+// Package apikey authenticates API clients by key and limits how much each
+// key may use the API. Keys are listed in a JSON file that only holds the
+// SHA-256 of every secret, so the file does not leak the keys themselves.
+// Every key has its own token bucket and daily quota. Usage is counted in
+// memory and, with a UsageStore, saved so quotas survive a restart
+package apikey
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Key is one client of the API
+type Key struct {
+	// ID names the key in logs and usage reports
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+	// SecretSHA256 is the hex SHA-256 of the secret the client sends
+	SecretSHA256 string `json:"secret_sha256"`
+	// RatePerMinute refills the token bucket (0 for no rate limit)
+	RatePerMinute float64 `json:"rate_per_minute,omitempty"`
+	// Burst is the size of the token bucket (RatePerMinute/6, at least 1, when 0)
+	Burst int `json:"burst,omitempty"`
+	// DailyQuota caps the requests per UTC day (0 for no quota)
+	DailyQuota int `json:"daily_quota,omitempty"`
+	// Admin keys may read the usage of every key
+	Admin bool `json:"admin,omitempty"`
+}
+
+// Keyring holds the keys loaded from a key file
+type Keyring struct {
+	keys   []*Key
+	byHash map[string]*Key
+}
+
+// HashSecret returns the SecretSHA256 value for secret
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// LoadFile reads a key file of the form {"keys": [{"id": ..., "secret_sha256": ...}, ...]}
+func LoadFile(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	var file struct {
+		Keys []*Key `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid key file %s: %w", path, err)
+	}
+	return NewKeyring(file.Keys)
+}
+
+// NewKeyring checks the keys for missing or repeated IDs and hashes
+func NewKeyring(keys []*Key) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no API keys given")
+	}
+	kr := &Keyring{keys: keys, byHash: make(map[string]*Key, len(keys))}
+	ids := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		if k.ID == "" {
+			return nil, fmt.Errorf("API key without id")
+		}
+		if ids[k.ID] {
+			return nil, fmt.Errorf("API key %q is listed twice", k.ID)
+		}
+		ids[k.ID] = true
+
+		k.SecretSHA256 = strings.ToLower(k.SecretSHA256)
+		if b, err := hex.DecodeString(k.SecretSHA256); err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("API key %q: secret_sha256 must be 64 hex digits, the output of: echo -n <key> | sha256sum", k.ID)
+		}
+		if kr.byHash[k.SecretSHA256] != nil {
+			return nil, fmt.Errorf("API keys %q and %q have the same secret", kr.byHash[k.SecretSHA256].ID, k.ID)
+		}
+		if k.RatePerMinute < 0 || k.Burst < 0 || k.DailyQuota < 0 {
+			return nil, fmt.Errorf("API key %q: limits must not be negative", k.ID)
+		}
+		kr.byHash[k.SecretSHA256] = k
+	}
+	return kr, nil
+}
+
+// Authenticate returns the key whose secret is secret, or nil
+func (kr *Keyring) Authenticate(secret string) *Key {
+	if secret == "" {
+		return nil
+	}
+	return kr.byHash[HashSecret(secret)]
+}
+
+// Keys returns every key in file order
+func (kr *Keyring) Keys() []*Key {
+	return kr.keys
+}
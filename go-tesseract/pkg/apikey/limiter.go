@@ -0,0 +1,241 @@
+package apikey
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Verdict says why a request was refused
+type Verdict string
+
+const (
+	Allowed       Verdict = ""
+	RateLimited   Verdict = "rate_limited"
+	QuotaExceeded Verdict = "quota_exceeded"
+)
+
+// Decision is the outcome of Limiter.Allow
+type Decision struct {
+	Verdict Verdict
+	// RetryAfter is how long a refused client should wait
+	RetryAfter time.Duration
+	// Remaining is what is left of the daily quota, -1 without one
+	Remaining int
+}
+
+// Usage counts the requests of one key, since the server started or, with
+// a UsageStore, since the key was first used. Today counts only the requests
+// charged to the daily quota
+type Usage struct {
+	KeyID         string     `json:"key_id"`
+	Name          string     `json:"name,omitempty"`
+	Requests      int64      `json:"requests"`
+	Today         int        `json:"today"`
+	DailyQuota    int        `json:"daily_quota,omitempty"`
+	RateLimited   int64      `json:"rate_limited"`
+	QuotaExceeded int64      `json:"quota_exceeded"`
+	LastUsed      *time.Time `json:"last_used,omitempty"`
+}
+
+// keyState is the bucket and counters of one key
+type keyState struct {
+	key    *Key
+	bucket *rate.Limiter
+	day    string
+	usage  Usage
+	// dirty is set when the counters changed since they were last saved
+	dirty bool
+}
+
+// Limiter applies the rate limit and daily quota of every key of a Keyring
+type Limiter struct {
+	mu    sync.Mutex
+	keys  []*keyState
+	state map[string]*keyState
+	now   func() time.Time
+
+	// store and stop are set by Persist
+	store *UsageStore
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// NewLimiter starts every key of kr with a full bucket and nothing used
+func NewLimiter(kr *Keyring) *Limiter {
+	l := &Limiter{state: make(map[string]*keyState), now: time.Now}
+	for _, k := range kr.Keys() {
+		s := &keyState{key: k, usage: Usage{KeyID: k.ID, Name: k.Name, DailyQuota: k.DailyQuota}}
+		if k.RatePerMinute > 0 {
+			burst := k.Burst
+			if burst == 0 {
+				burst = max(int(k.RatePerMinute/6), 1)
+			}
+			s.bucket = rate.NewLimiter(rate.Limit(k.RatePerMinute/60), burst)
+		}
+		l.keys = append(l.keys, s)
+		l.state[k.ID] = s
+	}
+	return l
+}
+
+// Allow counts one request of key against its daily quota, unless the key
+// is over its quota or out of tokens
+func (l *Limiter) Allow(key *Key) Decision {
+	return l.allow(key, true)
+}
+
+// Throttle counts one request of key that is not charged to the daily
+// quota, e.g. polling a job, unless the key is out of tokens
+func (l *Limiter) Throttle(key *Key) Decision {
+	return l.allow(key, false)
+}
+
+func (l *Limiter) allow(key *Key, charge bool) Decision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s := l.state[key.ID]
+	now := l.now().UTC()
+	if day := now.Format(time.DateOnly); day != s.day {
+		s.day = day
+		s.usage.Today = 0
+	}
+
+	if charge && key.DailyQuota > 0 && s.usage.Today >= key.DailyQuota {
+		s.usage.QuotaExceeded++
+		s.dirty = true
+		midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+		return Decision{Verdict: QuotaExceeded, RetryAfter: midnight.Sub(now), Remaining: 0}
+	}
+	if s.bucket != nil {
+		r := s.bucket.ReserveN(now, 1)
+		if delay := r.DelayFrom(now); delay > 0 {
+			r.CancelAt(now)
+			s.usage.RateLimited++
+			s.dirty = true
+			return Decision{Verdict: RateLimited, RetryAfter: delay, Remaining: l.remaining(s)}
+		}
+	}
+
+	s.usage.Requests++
+	if charge {
+		s.usage.Today++
+	}
+	s.usage.LastUsed = &now
+	s.dirty = true
+	return Decision{Verdict: Allowed, Remaining: l.remaining(s)}
+}
+
+func (l *Limiter) remaining(s *keyState) int {
+	if s.key.DailyQuota == 0 {
+		return -1
+	}
+	return max(s.key.DailyQuota-s.usage.Today, 0)
+}
+
+// Usage returns the counters of every key in key file order
+func (l *Limiter) Usage() []Usage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	today := l.now().UTC().Format(time.DateOnly)
+	out := make([]Usage, len(l.keys))
+	for i, s := range l.keys {
+		out[i] = s.usage
+		if s.day != today {
+			out[i].Today = 0
+		}
+	}
+	return out
+}
+
+// Persist restores the counters saved in store and saves them back every
+// interval and on Close, so the daily quota is not reset by a restart.
+// Rate limit buckets start full again. Keys no longer in the key file are
+// left alone
+func (l *Limiter) Persist(store *UsageStore, interval time.Duration) error {
+	saved, err := store.load()
+	if err != nil {
+		return fmt.Errorf("failed to load key usage: %w", err)
+	}
+
+	l.mu.Lock()
+	for _, s := range l.keys {
+		u, ok := saved[s.key.ID]
+		if !ok {
+			continue
+		}
+		s.day = u.Day
+		s.usage.Requests = u.Requests
+		s.usage.Today = u.Today
+		s.usage.RateLimited = u.RateLimited
+		s.usage.QuotaExceeded = u.QuotaExceeded
+		s.usage.LastUsed = u.LastUsed
+	}
+	l.store = store
+	l.stop = make(chan struct{})
+	l.done = make(chan struct{})
+	l.mu.Unlock()
+
+	go l.saver(interval)
+	return nil
+}
+
+// saver saves the counters every interval until Close
+func (l *Limiter) saver(interval time.Duration) {
+	defer close(l.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			if err := l.save(); err != nil {
+				log.Printf("apikey: %v", err)
+			}
+		}
+	}
+}
+
+// save writes the counters that changed since the last save
+func (l *Limiter) save() error {
+	l.mu.Lock()
+	var changed []storedUsage
+	for _, s := range l.keys {
+		if s.dirty {
+			changed = append(changed, storedUsage{Usage: s.usage, Day: s.day})
+			s.dirty = false
+		}
+	}
+	l.mu.Unlock()
+
+	if len(changed) == 0 {
+		return nil
+	}
+	if err := l.store.save(changed); err != nil {
+		// Try again on the next save
+		l.mu.Lock()
+		for _, u := range changed {
+			l.state[u.KeyID].dirty = true
+		}
+		l.mu.Unlock()
+		return fmt.Errorf("failed to save key usage: %w", err)
+	}
+	return nil
+}
+
+// Close stops saving and saves the counters a last time. It does nothing
+// without Persist
+func (l *Limiter) Close() error {
+	if l.store == nil {
+		return nil
+	}
+	close(l.stop)
+	<-l.done
+	return l.save()
+}
@@ -0,0 +1,97 @@
+package apikey
+
+import (
+	"testing"
+	"time"
+)
+
+// step is one request of a limiter test, made after advancing the clock
+type step struct {
+	name      string
+	advance   time.Duration
+	throttle  bool
+	verdict   Verdict
+	retry     time.Duration
+	remaining int
+}
+
+// runSteps sends the steps for key through l on a clock starting at start
+func runSteps(t *testing.T, l *Limiter, key *Key, start time.Time, steps []step) {
+	t.Helper()
+	now := start
+	l.now = func() time.Time { return now }
+	for _, s := range steps {
+		now = now.Add(s.advance)
+		var got Decision
+		if s.throttle {
+			got = l.Throttle(key)
+		} else {
+			got = l.Allow(key)
+		}
+		want := Decision{Verdict: s.verdict, RetryAfter: s.retry, Remaining: s.remaining}
+		if got != want {
+			t.Errorf("%s: got %+v, want %+v", s.name, got, want)
+		}
+	}
+}
+
+func newTestLimiter(t *testing.T, key *Key) *Limiter {
+	t.Helper()
+	key.SecretSHA256 = HashSecret(key.ID)
+	kr, err := NewKeyring([]*Key{key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewLimiter(kr)
+}
+
+func TestDailyQuotaRollsOverAtMidnight(t *testing.T) {
+	key := &Key{ID: "dms", DailyQuota: 2}
+	l := newTestLimiter(t, key)
+
+	runSteps(t, l, key, time.Date(2026, 3, 1, 23, 59, 0, 0, time.UTC), []step{
+		{name: "first", verdict: Allowed, remaining: 1},
+		{name: "second", verdict: Allowed, remaining: 0},
+		{name: "over quota", advance: 30 * time.Second, verdict: QuotaExceeded, retry: 30 * time.Second, remaining: 0},
+		{name: "polling is not charged", throttle: true, verdict: Allowed, remaining: 0},
+		{name: "next day", advance: 31 * time.Second, verdict: Allowed, remaining: 1},
+	})
+
+	got := l.Usage()[0]
+	if got.Requests != 4 || got.Today != 1 || got.QuotaExceeded != 1 || got.RateLimited != 0 {
+		t.Errorf("usage = %+v, want 4 requests, 1 today, 1 over quota", got)
+	}
+}
+
+func TestRateLimitRefillsBucket(t *testing.T) {
+	key := &Key{ID: "dms", RatePerMinute: 60, Burst: 3}
+	l := newTestLimiter(t, key)
+
+	runSteps(t, l, key, time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC), []step{
+		{name: "burst 1", verdict: Allowed, remaining: -1},
+		{name: "burst 2", verdict: Allowed, remaining: -1},
+		{name: "burst 3", verdict: Allowed, remaining: -1},
+		{name: "bucket empty", verdict: RateLimited, retry: time.Second, remaining: -1},
+		{name: "polling is limited too", throttle: true, verdict: RateLimited, retry: time.Second, remaining: -1},
+		{name: "half a token", advance: 500 * time.Millisecond, verdict: RateLimited, retry: 500 * time.Millisecond, remaining: -1},
+		{name: "one token", advance: 500 * time.Millisecond, verdict: Allowed, remaining: -1},
+	})
+
+	got := l.Usage()[0]
+	if got.Requests != 4 || got.RateLimited != 3 {
+		t.Errorf("usage = %+v, want 4 requests, 3 rate limited", got)
+	}
+}
+
+func TestUsageResetsTodayOnNewDay(t *testing.T) {
+	key := &Key{ID: "dms", DailyQuota: 5}
+	l := newTestLimiter(t, key)
+
+	runSteps(t, l, key, time.Date(2026, 3, 1, 22, 0, 0, 0, time.UTC), []step{
+		{name: "first", verdict: Allowed, remaining: 4},
+	})
+	l.now = func() time.Time { return time.Date(2026, 3, 2, 1, 0, 0, 0, time.UTC) }
+	if got := l.Usage()[0]; got.Today != 0 || got.Requests != 1 {
+		t.Errorf("usage after midnight = %+v, want 0 today, 1 request", got)
+	}
+}
@@ -0,0 +1,68 @@
+package apikey
+
+import (
+	"encoding/json"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// usageBucket holds the counters of every key as JSON, keyed by key ID
+var usageBucket = []byte("api_key_usage")
+
+// storedUsage is Usage with the UTC day its Today counts
+type storedUsage struct {
+	Usage
+	Day string `json:"day"`
+}
+
+// UsageStore persists usage counters in a bbolt database, so daily quotas
+// hold across restarts. It keeps its own bucket and may share the file
+type UsageStore struct {
+	db *bolt.DB
+}
+
+// NewUsageStore keeps counters in db, which the caller opens and closes
+func NewUsageStore(db *bolt.DB) (*UsageStore, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(usageBucket)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create usage bucket: %w", err)
+	}
+	return &UsageStore{db: db}, nil
+}
+
+// load returns the saved counters by key ID
+func (s *UsageStore) load() (map[string]storedUsage, error) {
+	out := make(map[string]storedUsage)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(usageBucket).ForEach(func(k, v []byte) error {
+			var u storedUsage
+			if err := json.Unmarshal(v, &u); err != nil {
+				return err
+			}
+			out[string(k)] = u
+			return nil
+		})
+	})
+	return out, err
+}
+
+// save writes the counters of usages in one transaction
+func (s *UsageStore) save(usages []storedUsage) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(usageBucket)
+		for _, u := range usages {
+			data, err := json.Marshal(u)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(u.KeyID), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,496 @@
+// Package jobs runs OCR requests in the background. A job is submitted with
+// its input file, waits in a queue until one of a fixed number of workers
+// picks it up, and is kept with its result in a bbolt Store, so clients
+// poll for the result instead of holding a request open and jobs survive
+// a restart of the server
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Status is where a job is in its life
+type Status string
+
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+	StatusCanceled  Status = "canceled"
+)
+
+// Finished reports whether the job will not run any more
+func (s Status) Finished() bool {
+	return s == StatusSucceeded || s == StatusFailed || s == StatusCanceled
+}
+
+// Job is one submitted request and, once finished, its result
+type Job struct {
+	ID     string `json:"id"`
+	Status Status `json:"status"`
+	// Progress goes from 0 to 1 as the pages of a document are done
+	Progress float64 `json:"progress"`
+	FileName string  `json:"file_name"`
+	// Owner is the ID of the API key that submitted the job, if any
+	Owner string `json:"owner,omitempty"`
+	// Params are the form values the job was submitted with
+	Params     map[string][]string `json:"params,omitempty"`
+	Error      string              `json:"error,omitempty"`
+	Result     json.RawMessage     `json:"result,omitempty"`
+	CreatedAt  time.Time           `json:"created_at"`
+	StartedAt  *time.Time          `json:"started_at,omitempty"`
+	FinishedAt *time.Time          `json:"finished_at,omitempty"`
+}
+
+// Handler is the worker body: it processes the input file of job and
+// returns the result, which is stored as JSON. progress reports the pages
+// done out of total
+type Handler func(ctx context.Context, job *Job, input string, progress func(done, total int)) (any, error)
+
+var (
+	// ErrQueueFull is returned by Submit when MaxPending jobs are waiting
+	ErrQueueFull = errors.New("job queue is full")
+	// ErrFinished is returned by Cancel for jobs that already ended
+	ErrFinished = errors.New("job already finished")
+	// ErrClosed is returned by Submit after Close
+	ErrClosed = errors.New("job queue is closed")
+)
+
+// Options tunes the queue
+type Options struct {
+	// Workers is how many jobs run at once (1 when zero)
+	Workers int
+	// MaxPending caps the jobs waiting for a worker (no limit when zero)
+	MaxPending int
+	// Timeout bounds every job (none when zero)
+	Timeout time.Duration
+	// Retention is how long finished jobs and their results are kept
+	// (forever when zero)
+	Retention time.Duration
+	// OnFinish is called with every job that ended, e.g. to notify the
+	// submitter. It must not block
+	OnFinish func(job *Job)
+}
+
+// Stats is a snapshot of the queue
+type Stats struct {
+	Workers    int `json:"workers"`
+	Pending    int `json:"pending"`
+	Running    int `json:"running"`
+	MaxPending int `json:"max_pending,omitempty"`
+}
+
+// Queue hands submitted jobs to a pool of workers
+type Queue struct {
+	store   *Store
+	dir     string
+	handler Handler
+	opts    Options
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	pending  []string
+	running  map[string]context.CancelFunc
+	canceled map[string]bool
+	closed   bool
+	// interrupted is set when Close gives up waiting for running jobs
+	interrupted bool
+	wg          sync.WaitGroup
+	// stop ends the pruning of expired jobs
+	stop chan struct{}
+}
+
+// New starts the workers. dir keeps the input files of unfinished jobs.
+// Jobs a previous process left queued or running are queued again
+func New(store *Store, dir string, handler Handler, opts Options) (*Queue, error) {
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create job directory: %w", err)
+	}
+
+	q := &Queue{
+		store:    store,
+		dir:      dir,
+		handler:  handler,
+		opts:     opts,
+		running:  make(map[string]context.CancelFunc),
+		canceled: make(map[string]bool),
+		stop:     make(chan struct{}),
+	}
+	q.cond = sync.NewCond(&q.mu)
+
+	if err := q.recover(); err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < opts.Workers; i++ {
+		q.wg.Add(1)
+		go q.worker()
+	}
+	if opts.Retention > 0 {
+		go q.pruner()
+	}
+	return q, nil
+}
+
+// recover queues the unfinished jobs of an earlier run in submission order
+func (q *Queue) recover() error {
+	all, err := q.store.List()
+	if err != nil {
+		return fmt.Errorf("failed to load jobs: %w", err)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].CreatedAt.Before(all[j].CreatedAt) })
+
+	for _, job := range all {
+		if job.Status.Finished() {
+			continue
+		}
+		if _, err := os.Stat(q.inputPath(job)); err != nil {
+			now := time.Now()
+			job.Status, job.Error, job.FinishedAt = StatusFailed, "input file lost", &now
+		} else {
+			job.Status, job.Progress, job.StartedAt = StatusQueued, 0, nil
+			q.pending = append(q.pending, job.ID)
+		}
+		if err := q.store.Put(job); err != nil {
+			return fmt.Errorf("failed to requeue job %s: %w", job.ID, err)
+		}
+	}
+	return nil
+}
+
+// inputPath is where the input file of job is kept until it finishes
+func (q *Queue) inputPath(job *Job) string {
+	return filepath.Join(q.dir, job.ID+filepath.Ext(job.FileName))
+}
+
+// Submit stores input and queues a job for it on behalf of owner, which
+// may be empty
+func (q *Queue) Submit(owner, fileName string, params map[string][]string, input []byte) (*Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return nil, ErrClosed
+	}
+	if q.opts.MaxPending > 0 && len(q.pending) >= q.opts.MaxPending {
+		return nil, ErrQueueFull
+	}
+
+	job := &Job{
+		ID:        uuid.NewString(),
+		Status:    StatusQueued,
+		FileName:  fileName,
+		Owner:     owner,
+		Params:    params,
+		CreatedAt: time.Now(),
+	}
+	if err := os.WriteFile(q.inputPath(job), input, 0600); err != nil {
+		return nil, fmt.Errorf("failed to store input: %w", err)
+	}
+	if err := q.store.Put(job); err != nil {
+		os.Remove(q.inputPath(job))
+		return nil, fmt.Errorf("failed to store job: %w", err)
+	}
+
+	q.pending = append(q.pending, job.ID)
+	q.cond.Signal()
+	return job, nil
+}
+
+// Get returns the job with id, or ErrNotFound
+func (q *Queue) Get(id string) (*Job, error) {
+	return q.store.Get(id)
+}
+
+// Cancel stops a queued or running job. Running jobs stop as soon as the
+// handler notices its context ended
+func (q *Queue) Cancel(id string) (*Job, error) {
+	q.mu.Lock()
+	job, err := q.store.Get(id)
+	if err != nil {
+		q.mu.Unlock()
+		return nil, err
+	}
+	if job.Status.Finished() {
+		q.mu.Unlock()
+		return job, ErrFinished
+	}
+
+	// A running job is reported finished by its worker
+	queued := false
+	if i := slices.Index(q.pending, id); i >= 0 {
+		q.pending = slices.Delete(q.pending, i, i+1)
+		os.Remove(q.inputPath(job))
+		queued = true
+	} else if cancel, ok := q.running[id]; ok {
+		q.canceled[id] = true
+		cancel()
+	}
+
+	now := time.Now()
+	job.Status, job.FinishedAt = StatusCanceled, &now
+	err = q.store.Put(job)
+	q.mu.Unlock()
+
+	if queued && err == nil {
+		q.finished(job)
+	}
+	return job, err
+}
+
+// Stats returns the number of workers and of pending and running jobs
+func (q *Queue) Stats() Stats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return Stats{Workers: q.opts.Workers, Pending: len(q.pending), Running: len(q.running), MaxPending: q.opts.MaxPending}
+}
+
+// Close stops taking jobs and waits for the running ones until ctx ends.
+// Jobs still running then are canceled and queued again for the next start;
+// the returned error names them and wraps ctx.Err()
+func (q *Queue) Close(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closed {
+		close(q.stop)
+	}
+	q.closed = true
+	q.cond.Broadcast()
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	q.mu.Lock()
+	q.interrupted = true
+	ids := make([]string, 0, len(q.running))
+	for id, cancel := range q.running {
+		ids = append(ids, id)
+		cancel()
+	}
+	q.mu.Unlock()
+	<-done
+	sort.Strings(ids)
+	return fmt.Errorf("interrupted jobs %s: %w", strings.Join(ids, ", "), ctx.Err())
+}
+
+// Sweep removes the files of the job directory that belong to no unfinished
+// job, e.g. inputs left behind when the process was killed, and returns
+// their names
+func (q *Queue) Sweep() ([]string, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	all, err := q.store.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load jobs: %w", err)
+	}
+	keep := make(map[string]bool)
+	for _, job := range all {
+		if !job.Status.Finished() {
+			keep[filepath.Base(q.inputPath(job))] = true
+		}
+	}
+
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read job directory: %w", err)
+	}
+	var removed []string
+	for _, entry := range entries {
+		if entry.IsDir() || keep[entry.Name()] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(q.dir, entry.Name())); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return removed, fmt.Errorf("failed to remove %s: %w", entry.Name(), err)
+		}
+		removed = append(removed, entry.Name())
+	}
+	return removed, nil
+}
+
+// Prune deletes the jobs that finished more than Options.Retention ago and
+// returns their IDs
+func (q *Queue) Prune() ([]string, error) {
+	if q.opts.Retention <= 0 {
+		return nil, nil
+	}
+	all, err := q.store.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load jobs: %w", err)
+	}
+	cutoff := time.Now().Add(-q.opts.Retention)
+	var pruned []string
+	for _, job := range all {
+		if !job.Status.Finished() || job.FinishedAt == nil || job.FinishedAt.After(cutoff) {
+			continue
+		}
+		if err := q.store.Delete(job.ID); err != nil {
+			return pruned, fmt.Errorf("failed to delete job %s: %w", job.ID, err)
+		}
+		pruned = append(pruned, job.ID)
+	}
+	return pruned, nil
+}
+
+// pruner runs Prune every tenth of the retention, but at least hourly and
+// at most every second, until the queue is closed
+func (q *Queue) pruner() {
+	ticker := time.NewTicker(max(min(q.opts.Retention/10, time.Hour), time.Second))
+	defer ticker.Stop()
+	for {
+		if pruned, err := q.Prune(); err != nil {
+			log.Printf("jobs: %v", err)
+		} else if len(pruned) > 0 {
+			log.Printf("jobs: removed %d jobs finished more than %s ago", len(pruned), q.opts.Retention)
+		}
+		select {
+		case <-q.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// worker runs pending jobs one at a time until the queue is closed
+func (q *Queue) worker() {
+	defer q.wg.Done()
+	for {
+		q.mu.Lock()
+		for len(q.pending) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if q.closed {
+			q.mu.Unlock()
+			return
+		}
+		id := q.pending[0]
+		q.pending = q.pending[1:]
+		ctx, cancel := context.WithCancel(context.Background())
+		q.running[id] = cancel
+		q.mu.Unlock()
+
+		q.run(ctx, id)
+		cancel()
+
+		q.mu.Lock()
+		delete(q.running, id)
+		delete(q.canceled, id)
+		q.mu.Unlock()
+	}
+}
+
+// run processes one job and records how it ended
+func (q *Queue) run(ctx context.Context, id string) {
+	// Cancel stores jobs under q.mu, so reading and marking the job running
+	// under it too cannot overwrite a cancellation
+	q.mu.Lock()
+	job, err := q.store.Get(id)
+	if err != nil {
+		q.mu.Unlock()
+		log.Printf("job %s: %v", id, err)
+		return
+	}
+	input := q.inputPath(job)
+	if job.Status != StatusQueued {
+		// Canceled between leaving the queue and starting
+		q.mu.Unlock()
+		os.Remove(input)
+		q.finished(job)
+		return
+	}
+	started := time.Now()
+	job.Status, job.StartedAt = StatusRunning, &started
+	q.save(job)
+	q.mu.Unlock()
+
+	if q.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, q.opts.Timeout)
+		defer cancel()
+	}
+
+	progress := func(done, total int) {
+		if total <= 0 {
+			return
+		}
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		// job is the running copy: once canceled it must not be saved over
+		// the canceled one
+		if q.canceled[id] {
+			return
+		}
+		job.Progress = float64(done) / float64(total)
+		q.save(job)
+	}
+	result, err := q.handler(ctx, job, input, progress)
+
+	q.mu.Lock()
+	canceled, interrupted := q.canceled[id], q.interrupted
+	q.mu.Unlock()
+
+	finished := time.Now()
+	switch {
+	case canceled:
+		job.Status = StatusCanceled
+	case interrupted && ctx.Err() != nil:
+		// Cut off by shutdown: keep the input and run it again after restart
+		job.Status, job.Progress, job.StartedAt = StatusQueued, 0, nil
+		q.save(job)
+		return
+	case err != nil:
+		job.Status, job.Error = StatusFailed, err.Error()
+	default:
+		data, err := json.Marshal(result)
+		if err != nil {
+			job.Status, job.Error = StatusFailed, fmt.Sprintf("failed to encode result: %v", err)
+			break
+		}
+		job.Status, job.Result, job.Progress = StatusSucceeded, data, 1
+	}
+	job.FinishedAt = &finished
+	q.save(job)
+	os.Remove(input)
+	q.finished(job)
+}
+
+// finished calls OnFinish, if any
+func (q *Queue) finished(job *Job) {
+	if q.opts.OnFinish != nil {
+		q.opts.OnFinish(job)
+	}
+}
+
+// save stores job, logging failures since workers have no caller to report to
+func (q *Queue) save(job *Job) {
+	if err := q.store.Put(job); err != nil {
+		log.Printf("job %s: failed to save: %v", job.ID, err)
+	}
+}
@@ -0,0 +1,141 @@
+package layout
+
+import (
+	"image"
+	"image/draw"
+	"math"
+	"strings"
+
+	"github.com/ToniBirat7/tesseract_ocr_ne/pkg/ocr"
+)
+
+// classify gives every line a role by comparing its height and stroke
+// width with the page medians, which stand for body text
+func classify(lines []Line, opts Options) {
+	var heights, strokes []float64
+	for _, l := range lines {
+		heights = append(heights, float64(l.Box.Height))
+		if l.StrokeWidth > 0 {
+			strokes = append(strokes, l.StrokeWidth)
+		}
+	}
+	medHeight, medStroke := median(heights), median(strokes)
+
+	for i := range lines {
+		l := &lines[i]
+		h := float64(l.Box.Height)
+		switch {
+		case h >= opts.HeadlineHeightRatio*medHeight:
+			l.Role = RoleHeadline
+		case medStroke > 0 && l.StrokeWidth >= opts.HeadlineStrokeRatio*medStroke:
+			l.Role = RoleHeadline
+		case h <= opts.CaptionHeightRatio*medHeight:
+			l.Role = RoleCaption
+		default:
+			l.Role = RoleBody
+		}
+	}
+}
+
+// groupArticles walks the lines in reading order. A headline after body
+// text, a change of column or a gap of ArticleGapRatio line heights inside
+// a column starts a new article; consecutive headline lines form one
+// multi-line headline, and a full-width headline keeps the column below it
+func groupArticles(lines []Line, opts Options) []Article {
+	var heights []float64
+	for _, l := range lines {
+		heights = append(heights, float64(l.Box.Height))
+	}
+	maxGap := int(opts.ArticleGapRatio * median(heights))
+
+	var articles []Article
+	var headline, body []string
+	var cur *Article
+	var box image.Rectangle
+	var prev *Line
+
+	finish := func() {
+		if cur == nil {
+			return
+		}
+		cur.Headline = strings.Join(headline, " ")
+		cur.Body = strings.Join(body, " ")
+		cur.Box = ocr.BoundingBox{X: box.Min.X, Y: box.Min.Y, Width: box.Dx(), Height: box.Dy()}
+		articles = append(articles, *cur)
+		cur, headline, body, box = nil, nil, nil, image.Rectangle{}
+	}
+
+	for i := range lines {
+		l := &lines[i]
+		newArticle := cur == nil
+		if prev != nil && prev.Column == l.Column && l.Box.Y-(prev.Box.Y+prev.Box.Height) > maxGap {
+			newArticle = true
+		}
+		// Articles stay inside a column, except below a full-width headline
+		if prev != nil && prev.Column != l.Column && !(prev.Column == FullWidth && len(body) == 0) {
+			newArticle = true
+		}
+		if l.Role == RoleHeadline && (len(body) > 0 || (cur != nil && len(cur.Captions) > 0)) {
+			newArticle = true
+		}
+
+		if newArticle {
+			finish()
+			cur = &Article{Index: len(articles), Column: l.Column}
+		}
+
+		switch l.Role {
+		case RoleHeadline:
+			headline = append(headline, l.Text)
+		case RoleCaption:
+			cur.Captions = append(cur.Captions, l.Text)
+		default:
+			body = append(body, l.Text)
+		}
+		box = box.Union(l.Box.Rect())
+		prev = l
+	}
+	finish()
+	return articles
+}
+
+// strokeWidth measures how thick the strokes of a line are: the mean
+// length of horizontal ink runs through the middle half of its box. Runs
+// longer than half the line height are rules or headline bars, not strokes
+func strokeWidth(g *image.Gray, r image.Rectangle, dark uint8) float64 {
+	r = r.Intersect(g.Bounds())
+	if r.Empty() {
+		return 0
+	}
+	maxRun := r.Dy() / 2
+
+	var total, count int
+	for y := r.Min.Y + r.Dy()/4; y < r.Min.Y+r.Dy()*3/4; y++ {
+		run := 0
+		for x := r.Min.X; x <= r.Max.X; x++ {
+			if x < r.Max.X && g.GrayAt(x, y).Y < dark {
+				run++
+				continue
+			}
+			if run > 0 && run < maxRun {
+				total += run
+				count++
+			}
+			run = 0
+		}
+	}
+	if count == 0 {
+		return 0
+	}
+	return math.Round(float64(total)/float64(count)*100) / 100
+}
+
+// toGray converts the page to grayscale once for stroke measurements
+func toGray(img image.Image) *image.Gray {
+	if g, ok := img.(*image.Gray); ok {
+		return g
+	}
+	g := image.NewGray(img.Bounds())
+	draw.Draw(g, g.Bounds(), img, img.Bounds().Min, draw.Src)
+	return g
+}
@@ -0,0 +1,118 @@
+package layout
+
+import (
+	"sort"
+)
+
+// gutter is a vertical strip of whitespace between columns
+type gutter struct {
+	start, end int
+}
+
+// detectColumns finds the text columns by whitespace projection: every
+// line covers its x range, and wide runs of x that almost no line covers
+// are gutters between columns. Full-width lines crossing a gutter are
+// tolerated up to GutterCoverage of all lines
+func detectColumns(lines []Line, opts Options) []Column {
+	minX, maxX := lines[0].Box.X, lines[0].Box.X+lines[0].Box.Width
+	for _, l := range lines[1:] {
+		minX = min(minX, l.Box.X)
+		maxX = max(maxX, l.Box.X+l.Box.Width)
+	}
+	width := maxX - minX
+	if width <= 0 {
+		return []Column{{Index: 0, X: minX, Width: width}}
+	}
+
+	coverage := make([]int, width)
+	for _, l := range lines {
+		for x := l.Box.X - minX; x < l.Box.X+l.Box.Width-minX; x++ {
+			coverage[x]++
+		}
+	}
+
+	allowed := int(opts.GutterCoverage * float64(len(lines)))
+	minGutter := max(int(opts.MinGutterRatio*float64(width)), 1)
+
+	var gutters []gutter
+	start := -1
+	for x := 0; x <= width; x++ {
+		empty := x < width && coverage[x] <= allowed
+		switch {
+		case empty && start < 0:
+			start = x
+		case !empty && start >= 0:
+			// Runs touching the edges are margins, not gutters
+			if start > 0 && x < width && x-start >= minGutter {
+				gutters = append(gutters, gutter{start: start + minX, end: x + minX})
+			}
+			start = -1
+		}
+	}
+
+	// Keep the widest gutters when there are more than MaxColumns-1
+	if len(gutters) > opts.MaxColumns-1 {
+		sort.Slice(gutters, func(i, j int) bool {
+			return gutters[i].end-gutters[i].start > gutters[j].end-gutters[j].start
+		})
+		gutters = gutters[:opts.MaxColumns-1]
+		sort.Slice(gutters, func(i, j int) bool { return gutters[i].start < gutters[j].start })
+	}
+
+	columns := make([]Column, 0, len(gutters)+1)
+	left := minX
+	for _, g := range gutters {
+		columns = append(columns, Column{Index: len(columns), X: left, Width: g.start - left})
+		left = g.end
+	}
+	columns = append(columns, Column{Index: len(columns), X: left, Width: maxX - left})
+	return columns
+}
+
+// assignColumns sets the column of every line. A line reaching well into
+// more than one column spans them and is FullWidth
+func assignColumns(lines []Line, columns []Column) {
+	for i := range lines {
+		l := &lines[i]
+		if len(columns) == 1 {
+			l.Column = 0
+			continue
+		}
+
+		l.Column = FullWidth
+		hits := 0
+		for _, c := range columns {
+			overlap := min(l.Box.X+l.Box.Width, c.X+c.Width) - max(l.Box.X, c.X)
+			if overlap*10 > c.Width {
+				hits++
+				l.Column = c.Index
+			}
+		}
+		if hits != 1 {
+			l.Column = nearestColumn(l, columns, hits)
+		}
+	}
+}
+
+// nearestColumn resolves lines touching no column (inside a gutter) to the
+// column around their center, and lines touching several to FullWidth
+func nearestColumn(l *Line, columns []Column, hits int) int {
+	if hits > 1 {
+		return FullWidth
+	}
+	center := l.Box.X + l.Box.Width/2
+	best, bestDist := 0, -1
+	for _, c := range columns {
+		dist := 0
+		switch {
+		case center < c.X:
+			dist = c.X - center
+		case center > c.X+c.Width:
+			dist = center - c.X - c.Width
+		}
+		if bestDist < 0 || dist < bestDist {
+			best, bestDist = c.Index, dist
+		}
+	}
+	return best
+}
@@ -0,0 +1,296 @@
+// Package layout reconstructs the structure of multi-column pages such as
+// newspapers and Bar Association notices from OCR lines: it finds the text
+// columns, puts lines in reading order, tells headlines from body text and
+// captions, and groups them into articles.
+//
+// It grew out of the Gotesseract_Practice/bounding_box.go prototype; the
+// fixed pixel thresholds there are replaced by ratios of the page's median
+// line height, so the same Options work at any scan resolution
+package layout
+
+import (
+	"image"
+	"sort"
+	"strings"
+
+	"github.com/ToniBirat7/tesseract_ocr_ne/pkg/ocr"
+)
+
+// Role is what a line is used for on the page
+type Role string
+
+const (
+	RoleHeadline Role = "headline"
+	RoleBody     Role = "body"
+	RoleCaption  Role = "caption"
+)
+
+// FullWidth is the column of lines that span more than one column
+const FullWidth = -1
+
+// Options tunes the analysis. Zero fields take the defaults listed
+type Options struct {
+	// HeadlineHeightRatio marks lines at least this many times the median
+	// line height as headlines (1.4)
+	HeadlineHeightRatio float64
+	// HeadlineStrokeRatio marks lines whose stroke width is at least this
+	// many times the median as (bold) headlines (1.3). Needs the page image
+	HeadlineStrokeRatio float64
+	// CaptionHeightRatio marks lines at most this many times the median
+	// line height as captions (0.8)
+	CaptionHeightRatio float64
+	// ArticleGapRatio starts a new article after a vertical gap of this
+	// many median line heights inside a column (2.5)
+	ArticleGapRatio float64
+	// MinGutterRatio is the narrowest whitespace between columns, as a
+	// fraction of the content width (0.02)
+	MinGutterRatio float64
+	// GutterCoverage is the largest share of lines that may cross a gutter,
+	// e.g. full-width headlines (0.1)
+	GutterCoverage float64
+	// MaxColumns caps the number of columns detected (6)
+	MaxColumns int
+	// DarkThreshold is the gray level (0-255) below which a pixel is ink
+	// when measuring stroke width (156)
+	DarkThreshold uint8
+	// SkipTop and SkipBottom ignore lines starting above SkipTop pixels or
+	// ending below the last SkipBottom pixels, e.g. a website menu (0)
+	SkipTop, SkipBottom int
+	// NoiseWords drops lines shorter than NoiseMaxRunes (20) that contain
+	// one of these words, such as share buttons on screenshots
+	NoiseWords    []string
+	NoiseMaxRunes int
+}
+
+// DefaultOptions returns the defaults used for zero fields
+func DefaultOptions() Options {
+	return Options{
+		HeadlineHeightRatio: 1.4,
+		HeadlineStrokeRatio: 1.3,
+		CaptionHeightRatio:  0.8,
+		ArticleGapRatio:     2.5,
+		MinGutterRatio:      0.02,
+		GutterCoverage:      0.1,
+		MaxColumns:          6,
+		DarkThreshold:       156,
+		NoiseMaxRunes:       20,
+	}
+}
+
+// withDefaults fills zero fields from DefaultOptions
+func (o Options) withDefaults() Options {
+	d := DefaultOptions()
+	if o.HeadlineHeightRatio <= 0 {
+		o.HeadlineHeightRatio = d.HeadlineHeightRatio
+	}
+	if o.HeadlineStrokeRatio <= 0 {
+		o.HeadlineStrokeRatio = d.HeadlineStrokeRatio
+	}
+	if o.CaptionHeightRatio <= 0 {
+		o.CaptionHeightRatio = d.CaptionHeightRatio
+	}
+	if o.ArticleGapRatio <= 0 {
+		o.ArticleGapRatio = d.ArticleGapRatio
+	}
+	if o.MinGutterRatio <= 0 {
+		o.MinGutterRatio = d.MinGutterRatio
+	}
+	if o.GutterCoverage <= 0 {
+		o.GutterCoverage = d.GutterCoverage
+	}
+	if o.MaxColumns <= 0 {
+		o.MaxColumns = d.MaxColumns
+	}
+	if o.DarkThreshold == 0 {
+		o.DarkThreshold = d.DarkThreshold
+	}
+	if o.NoiseMaxRunes <= 0 {
+		o.NoiseMaxRunes = d.NoiseMaxRunes
+	}
+	return o
+}
+
+// Column is the horizontal extent of one text column
+type Column struct {
+	Index int `json:"index"`
+	X     int `json:"x"`
+	Width int `json:"width"`
+}
+
+// Line is an OCR line placed on the page
+type Line struct {
+	// Order is the position in reading order, from 0
+	Order       int             `json:"order"`
+	Text        string          `json:"text"`
+	Confidence  float64         `json:"confidence"`
+	Box         ocr.BoundingBox `json:"box"`
+	Column      int             `json:"column"`
+	Role        Role            `json:"role"`
+	StrokeWidth float64         `json:"stroke_width,omitempty"`
+}
+
+// Article is a headline with the body lines and captions that follow it
+type Article struct {
+	Index    int             `json:"index"`
+	Headline string          `json:"headline,omitempty"`
+	Body     string          `json:"body,omitempty"`
+	Captions []string        `json:"captions,omitempty"`
+	Column   int             `json:"column"`
+	Box      ocr.BoundingBox `json:"box"`
+}
+
+// Page is the layout of one page
+type Page struct {
+	Width    int       `json:"width"`
+	Height   int       `json:"height"`
+	Columns  []Column  `json:"columns"`
+	Lines    []Line    `json:"lines"`
+	Articles []Article `json:"articles"`
+	// Text is the page text in reading order, articles separated by a blank line
+	Text string `json:"text"`
+}
+
+// Analyze lays out lines (with boxes, as returned with
+// OCRConfig.IncludeLines) on a page of the given size. img is optional and
+// only used to measure stroke width for bold headlines; it must match the
+// coordinates of the boxes
+func Analyze(lines []ocr.ExtractedLine, bounds image.Rectangle, img image.Image, opts Options) *Page {
+	opts = opts.withDefaults()
+	page := &Page{Width: bounds.Dx(), Height: bounds.Dy()}
+
+	var gray *image.Gray
+	if img != nil {
+		gray = toGray(img)
+	}
+
+	for _, l := range lines {
+		if !keepLine(l, bounds, opts) {
+			continue
+		}
+		line := Line{Text: l.Text, Confidence: l.Confidence, Box: l.Box}
+		if gray != nil {
+			line.StrokeWidth = strokeWidth(gray, l.Box.Rect(), opts.DarkThreshold)
+		}
+		page.Lines = append(page.Lines, line)
+	}
+	if len(page.Lines) == 0 {
+		return page
+	}
+
+	page.Columns = detectColumns(page.Lines, opts)
+	assignColumns(page.Lines, page.Columns)
+	page.Lines = readingOrder(page.Lines)
+	classify(page.Lines, opts)
+	page.Articles = groupArticles(page.Lines, opts)
+
+	var texts []string
+	for _, a := range page.Articles {
+		var parts []string
+		for _, part := range append([]string{a.Headline, a.Body}, a.Captions...) {
+			if part != "" {
+				parts = append(parts, part)
+			}
+		}
+		texts = append(texts, strings.Join(parts, "\n"))
+	}
+	page.Text = strings.Join(texts, "\n\n")
+	return page
+}
+
+// AnalyzeResult lays out an OCRResult extracted with IncludeLines. When
+// bounds is empty the page box of result.Page is used, so also request a
+// Granularity. img is ignored when the page was rotated or deskewed before
+// recognition, since its pixels no longer match the boxes
+func AnalyzeResult(result *ocr.OCRResult, bounds image.Rectangle, img image.Image, opts Options) *Page {
+	if o := result.Orientation; o != nil && o.Applied {
+		img = nil
+	}
+	if p := result.Preprocessing; p != nil && p.SkewAngle != 0 {
+		img = nil
+	}
+	if result.Page != nil && bounds.Empty() {
+		bounds = result.Page.Box.Rect()
+	}
+	return Analyze(result.Lines, bounds, img, opts)
+}
+
+// Document is the layout of every page of a multi-page document
+type Document struct {
+	PageCount int     `json:"page_count"`
+	Pages     []*Page `json:"pages"`
+}
+
+// AnalyzeDocument lays out every page of a PDF or TIFF result. Page images
+// are not kept after recognition, so headlines are found by height only
+func AnalyzeDocument(doc *ocr.DocumentResult, opts Options) *Document {
+	out := &Document{PageCount: doc.PageCount}
+	for _, p := range doc.Pages {
+		out.Pages = append(out.Pages, AnalyzeResult(p.OCRResult, image.Rectangle{}, nil, opts))
+	}
+	return out
+}
+
+// keepLine applies the SkipTop/SkipBottom margins and noise words
+func keepLine(l ocr.ExtractedLine, bounds image.Rectangle, opts Options) bool {
+	if strings.TrimSpace(l.Text) == "" {
+		return false
+	}
+	if opts.SkipTop > 0 && l.Box.Y < bounds.Min.Y+opts.SkipTop {
+		return false
+	}
+	if opts.SkipBottom > 0 && l.Box.Y+l.Box.Height > bounds.Max.Y-opts.SkipBottom {
+		return false
+	}
+	if len([]rune(l.Text)) < opts.NoiseMaxRunes {
+		for _, w := range opts.NoiseWords {
+			if strings.Contains(l.Text, w) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// readingOrder sorts lines top to bottom, and inside each band between
+// full-width lines reads every column top to bottom before the next one
+func readingOrder(lines []Line) []Line {
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].Box.Y < lines[j].Box.Y })
+
+	ordered := make([]Line, 0, len(lines))
+	var band []Line
+	flush := func() {
+		sort.SliceStable(band, func(i, j int) bool {
+			if band[i].Column != band[j].Column {
+				return band[i].Column < band[j].Column
+			}
+			return band[i].Box.Y < band[j].Box.Y
+		})
+		ordered = append(ordered, band...)
+		band = band[:0]
+	}
+
+	for _, l := range lines {
+		if l.Column == FullWidth {
+			flush()
+			ordered = append(ordered, l)
+			continue
+		}
+		band = append(band, l)
+	}
+	flush()
+
+	for i := range ordered {
+		ordered[i].Order = i
+	}
+	return ordered
+}
+
+// median returns the median of values, 0 when empty
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	return sorted[len(sorted)/2]
+}
@@ -0,0 +1,76 @@
+package ocr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// TimeoutError is returned when the context deadline passes before
+// Tesseract finishes recognizing the image
+type TimeoutError struct {
+	Elapsed time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("ocr timed out after %s", e.Elapsed.Round(time.Millisecond))
+}
+
+// Unwrap lets errors.Is(err, context.DeadlineExceeded) match a TimeoutError
+func (e *TimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// IsTimeout reports whether err (or anything it wraps) is a TimeoutError
+func IsTimeout(err error) bool {
+	var te *TimeoutError
+	return errors.As(err, &te)
+}
+
+// recognition is the raw output of one Tesseract pass
+type recognition struct {
+	lines   []ExtractedLine
+	avgConf float64
+	page    *Page
+}
+
+// runRecognition runs fn on its own goroutine so the caller can return as
+// soon as ctx ends. It does not stop fn: gosseract offers no way to
+// interrupt Tesseract, so an abandoned page is still recognized to the end
+// and its result dropped. fn keeps its client until then, so abandoned work
+// counts against the pool of an Engine, or the NumCPU slots of the
+// package-level functions, and later calls wait for it
+func runRecognition(ctx context.Context, fn func() (*recognition, error)) (*recognition, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, contextError(err, 0)
+	}
+
+	type outcome struct {
+		rec *recognition
+		err error
+	}
+	// Buffered so an abandoned goroutine can still deliver and exit
+	done := make(chan outcome, 1)
+	start := time.Now()
+
+	go func() {
+		rec, err := fn()
+		done <- outcome{rec, err}
+	}()
+
+	select {
+	case out := <-done:
+		return out.rec, out.err
+	case <-ctx.Done():
+		return nil, contextError(ctx.Err(), time.Since(start))
+	}
+}
+
+// contextError maps a context error onto the package's error types
+func contextError(err error, elapsed time.Duration) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &TimeoutError{Elapsed: elapsed}
+	}
+	return fmt.Errorf("ocr canceled: %w", err)
+}
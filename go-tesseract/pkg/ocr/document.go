@@ -0,0 +1,97 @@
+package ocr
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// PageResult is the OCR result of one page of a multi-page document
+type PageResult struct {
+	PageNumber int `json:"page_number"`
+	*OCRResult
+}
+
+// DocumentResult holds the result of every page plus the combined text,
+// confidence and line count of the whole document
+type DocumentResult struct {
+	OCRResult
+	PageCount int          `json:"page_count"`
+	Pages     []PageResult `json:"pages"`
+}
+
+// extractPages runs OCR on each of count pages in order and combines the
+// results. page is called for each page in turn, so a page image is only
+// produced once the one before it has been recognized
+func extractPages(ctx context.Context, clients clientProvider, count int, page func(i int) (imageSource, error), config *OCRConfig) (_ *DocumentResult, err error) {
+	ctx, span := tracer.Start(ctx, "ocr.ExtractDocument", trace.WithAttributes(attribute.Int("ocr.page_count", count)))
+	defer func() { endSpan(span, err) }()
+
+	doc := &DocumentResult{PageCount: count}
+
+	for i := 0; i < count; i++ {
+		src, err := page(i)
+		if err != nil {
+			return nil, fmt.Errorf("page %d: %w", i+1, err)
+		}
+
+		result, err := extract(ctx, clients, src, pageConfig(config, i))
+		if err != nil {
+			return nil, fmt.Errorf("page %d: %w", i+1, err)
+		}
+		if result.Page != nil {
+			result.Page.Index = i
+		}
+		doc.Pages = append(doc.Pages, PageResult{PageNumber: i + 1, OCRResult: result})
+		if config != nil && config.OnPage != nil {
+			config.OnPage(i+1, count, result)
+		}
+		if config != nil && config.Progress != nil {
+			config.Progress(i+1, count)
+		}
+	}
+
+	doc.OCRResult = combinePages(doc.Pages)
+	return doc, nil
+}
+
+// pageConfig gives every page its own preprocessing debug directory. Pages
+// do not keep their pixels, which would hold the whole document decoded
+func pageConfig(config *OCRConfig, i int) *OCRConfig {
+	if config == nil || (config.Preprocess.DebugDir == "" && !config.KeepPixels) {
+		return config
+	}
+	c := *config
+	c.KeepPixels = false
+	if c.Preprocess.DebugDir != "" {
+		c.Preprocess.DebugDir = filepath.Join(config.Preprocess.DebugDir, fmt.Sprintf("page-%03d", i+1))
+	}
+	return &c
+}
+
+// combinePages merges page results into one. Page confidences are taken
+// over every recognized line, so the average is weighted by those lines,
+// not by the lines left after filtering
+func combinePages(pages []PageResult) OCRResult {
+	var combined OCRResult
+	var texts []string
+	var weighted float64
+
+	for _, p := range pages {
+		if p.Text != "" {
+			texts = append(texts, p.Text)
+		}
+		combined.LineCount += p.LineCount
+		combined.Lines = append(combined.Lines, p.Lines...)
+		combined.recognized += p.recognized
+		weighted += p.AverageConfidence * float64(p.recognized)
+	}
+
+	combined.Text = strings.Join(texts, "\n\n")
+	combined.AverageConfidence = mean(weighted, combined.recognized)
+	return combined
+}
@@ -0,0 +1,391 @@
+package ocr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/otiai10/gosseract/v2"
+)
+
+// poolKey identifies clients that can be shared: a client is initialized
+// for one language set ("nep" or "nep+eng") and one page segmentation mode
+type poolKey struct {
+	language string
+	psm      int
+}
+
+func configKey(config *OCRConfig) poolKey {
+	return poolKey{language: strings.Join(config.languages(), "+"), psm: config.PageSegMode}
+}
+
+// clientProvider hands out Tesseract clients for a single recognition
+type clientProvider interface {
+	acquire(ctx context.Context, key poolKey) (*gosseract.Client, error)
+	// release returns the client; healthy is false when recognition failed
+	// and the client should not be trusted again
+	release(key poolKey, client *gosseract.Client, healthy bool)
+}
+
+// oneShot creates a fresh client for every call and closes it afterwards.
+// It backs the package-level Extract functions
+type oneShot struct{}
+
+// oneShotSlots bounds the clients of the package-level functions, including
+// those still recognizing a page their caller gave up on
+var oneShotSlots = make(chan struct{}, runtime.NumCPU())
+
+func (oneShot) acquire(ctx context.Context, key poolKey) (*gosseract.Client, error) {
+	select {
+	case oneShotSlots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, contextError(ctx.Err(), 0)
+	}
+	client, err := newClient(key)
+	if err != nil {
+		<-oneShotSlots
+		return nil, err
+	}
+	return client, nil
+}
+
+func (oneShot) release(key poolKey, client *gosseract.Client, healthy bool) {
+	client.Close()
+	<-oneShotSlots
+}
+
+// newClient creates a client configured for key
+func newClient(key poolKey) (*gosseract.Client, error) {
+	client := gosseract.NewClient()
+
+	if err := client.SetLanguage(strings.Split(key.language, "+")...); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to set language: %w", err)
+	}
+
+	if key.psm > 0 {
+		if err := client.SetPageSegMode(gosseract.PageSegMode(key.psm)); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("failed to set page segmentation mode: %w", err)
+		}
+	}
+
+	return client, nil
+}
+
+var (
+	// ErrLanguageNotInstalled is returned for languages without traineddata
+	ErrLanguageNotInstalled = errors.New("language not installed")
+	// ErrTooManyPools is returned when a new language/PSM pair would exceed
+	// EngineOptions.MaxPools and every pool is busy
+	ErrTooManyPools = errors.New("too many client pools in use")
+)
+
+// EngineOptions configures an Engine
+type EngineOptions struct {
+	// PoolSize is the maximum number of clients kept per language/PSM pair.
+	// Zero means runtime.NumCPU()
+	PoolSize int
+	// MaxPools caps the language/PSM pairs with a pool. Zero means 8
+	MaxPools int
+	// IdleTimeout is how long an unused pool keeps its clients before it
+	// is dropped. Zero means 10 minutes
+	IdleTimeout time.Duration
+}
+
+// Engine keeps warm Tesseract clients so the traineddata is loaded once per
+// client instead of once per image. It is safe for concurrent use; callers
+// beyond PoolSize wait for a free client. Pools are only created for
+// installed languages, at most MaxPools of them, and dropped once idle
+type Engine struct {
+	size        int
+	maxPools    int
+	idleTimeout time.Duration
+
+	mu     sync.Mutex
+	pools  map[poolKey]*clientPool
+	closed bool
+	// installed caches the traineddata found on first use
+	installed map[string]bool
+}
+
+// NewEngine creates an Engine. Clients are created lazily on first use
+func NewEngine(opts EngineOptions) *Engine {
+	size := opts.PoolSize
+	if size <= 0 {
+		size = runtime.NumCPU()
+	}
+	maxPools := opts.MaxPools
+	if maxPools <= 0 {
+		maxPools = 8
+	}
+	idleTimeout := opts.IdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = 10 * time.Minute
+	}
+	return &Engine{size: size, maxPools: maxPools, idleTimeout: idleTimeout, pools: make(map[poolKey]*clientPool)}
+}
+
+// clientPool is a bounded set of clients for one key
+type clientPool struct {
+	// idle holds clients ready for reuse
+	idle chan *gosseract.Client
+	// slots holds one token per live client and bounds the pool size
+	slots   chan struct{}
+	waiting atomic.Int64
+	inUse   atomic.Int64
+
+	// refs counts the callers between acquire and release, and lastUsed is
+	// when the last one left; both are guarded by Engine.mu
+	refs     int
+	lastUsed time.Time
+}
+
+// pool returns the pool for key, creating it if there is room, and takes a
+// reference the caller gives back with unref or release
+func (e *Engine) pool(key poolKey) (*clientPool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return nil, fmt.Errorf("ocr engine is closed")
+	}
+	e.evictIdle(time.Now())
+
+	p, ok := e.pools[key]
+	if !ok {
+		if err := e.checkInstalled(key.language); err != nil {
+			return nil, err
+		}
+		if len(e.pools) >= e.maxPools && !e.evictOldest() {
+			return nil, ErrTooManyPools
+		}
+		p = &clientPool{
+			idle:  make(chan *gosseract.Client, e.size),
+			slots: make(chan struct{}, e.size),
+		}
+		e.pools[key] = p
+	}
+	p.refs++
+	return p, nil
+}
+
+// unref gives back the reference of a caller that got no client
+func (e *Engine) unref(p *clientPool) {
+	e.mu.Lock()
+	p.refs--
+	p.lastUsed = time.Now()
+	e.mu.Unlock()
+}
+
+// checkInstalled fails unless every language of language ("nep+eng") has
+// traineddata. When the tessdata directory cannot be listed the check is
+// left to Tesseract, which fails when it loads the language
+func (e *Engine) checkInstalled(language string) error {
+	if e.installed == nil {
+		langs, err := AvailableLanguages()
+		if err != nil || len(langs) == 0 {
+			return nil
+		}
+		e.installed = make(map[string]bool, len(langs))
+		for _, lang := range langs {
+			e.installed[lang] = true
+		}
+	}
+	for _, lang := range strings.Split(language, "+") {
+		if !e.installed[lang] {
+			return fmt.Errorf("%w: %s", ErrLanguageNotInstalled, lang)
+		}
+	}
+	return nil
+}
+
+// evictIdle drops the pools nobody used for idleTimeout
+func (e *Engine) evictIdle(now time.Time) {
+	for key, p := range e.pools {
+		if p.refs == 0 && now.Sub(p.lastUsed) > e.idleTimeout {
+			p.drain()
+			delete(e.pools, key)
+		}
+	}
+}
+
+// evictOldest drops the least recently used pool that nobody is using
+func (e *Engine) evictOldest() bool {
+	var oldest *clientPool
+	var oldestKey poolKey
+	for key, p := range e.pools {
+		if p.refs == 0 && (oldest == nil || p.lastUsed.Before(oldest.lastUsed)) {
+			oldest, oldestKey = p, key
+		}
+	}
+	if oldest == nil {
+		return false
+	}
+	oldest.drain()
+	delete(e.pools, oldestKey)
+	return true
+}
+
+func (e *Engine) acquire(ctx context.Context, key poolKey) (*gosseract.Client, error) {
+	p, err := e.pool(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fast path: a warm client is waiting
+	select {
+	case client := <-p.idle:
+		p.inUse.Add(1)
+		return client, nil
+	default:
+	}
+
+	p.waiting.Add(1)
+	defer p.waiting.Add(-1)
+
+	select {
+	case client := <-p.idle:
+		p.inUse.Add(1)
+		return client, nil
+	case p.slots <- struct{}{}:
+		client, err := newClient(key)
+		if err != nil {
+			<-p.slots
+			e.unref(p)
+			return nil, err
+		}
+		p.inUse.Add(1)
+		return client, nil
+	case <-ctx.Done():
+		e.unref(p)
+		return nil, contextError(ctx.Err(), 0)
+	}
+}
+
+// release runs under the engine lock, so Close cannot drain the pool
+// between the check and the return of the client. The caller's reference
+// keeps the pool from being evicted meanwhile
+func (e *Engine) release(key poolKey, client *gosseract.Client, healthy bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	p := e.pools[key]
+	p.refs--
+	p.lastUsed = time.Now()
+	p.inUse.Add(-1)
+	if !healthy || e.closed {
+		client.Close()
+		<-p.slots
+		return
+	}
+	p.idle <- client
+}
+
+// Close frees all idle clients. Clients still in use are freed when their
+// recognition finishes; further Extract calls fail
+func (e *Engine) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.closed = true
+	for _, p := range e.pools {
+		p.drain()
+	}
+	return nil
+}
+
+// drain closes every idle client
+func (p *clientPool) drain() {
+	for {
+		select {
+		case client := <-p.idle:
+			client.Close()
+			<-p.slots
+		default:
+			return
+		}
+	}
+}
+
+// PoolStats describes one client pool
+type PoolStats struct {
+	Language    string `json:"language"`
+	PageSegMode int    `json:"page_seg_mode"`
+	Capacity    int    `json:"capacity"`
+	Clients     int    `json:"clients"`
+	Idle        int    `json:"idle"`
+	InUse       int    `json:"in_use"`
+	Waiting     int    `json:"waiting"`
+}
+
+// EngineStats is a snapshot of all pools of an Engine
+type EngineStats struct {
+	Pools []PoolStats `json:"pools"`
+}
+
+// Stats returns the current size and queue depth of every pool
+func (e *Engine) Stats() EngineStats {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	stats := EngineStats{Pools: []PoolStats{}}
+	for key, p := range e.pools {
+		stats.Pools = append(stats.Pools, PoolStats{
+			Language:    key.language,
+			PageSegMode: key.psm,
+			Capacity:    e.size,
+			Clients:     len(p.slots),
+			Idle:        len(p.idle),
+			InUse:       int(p.inUse.Load()),
+			Waiting:     int(p.waiting.Load()),
+		})
+	}
+	sort.Slice(stats.Pools, func(i, j int) bool {
+		if stats.Pools[i].Language != stats.Pools[j].Language {
+			return stats.Pools[i].Language < stats.Pools[j].Language
+		}
+		return stats.Pools[i].PageSegMode < stats.Pools[j].PageSegMode
+	})
+	return stats
+}
+
+// ExtractFromImage performs OCR on an image file using a pooled client
+func (e *Engine) ExtractFromImage(ctx context.Context, imagePath string, config *OCRConfig) (*OCRResult, error) {
+	return extract(ctx, e, imageSource{path: imagePath}, config)
+}
+
+// ExtractFromBytes performs OCR on an encoded image held in memory using a pooled client
+func (e *Engine) ExtractFromBytes(ctx context.Context, data []byte, config *OCRConfig) (*OCRResult, error) {
+	src, err := bytesSource(data)
+	if err != nil {
+		return nil, err
+	}
+	return extract(ctx, e, src, config)
+}
+
+// ExtractFromReader reads an encoded image from r and performs OCR on it using a pooled client
+func (e *Engine) ExtractFromReader(ctx context.Context, r io.Reader, config *OCRConfig) (*OCRResult, error) {
+	src, err := readerSource(r)
+	if err != nil {
+		return nil, err
+	}
+	return extract(ctx, e, src, config)
+}
+
+// ExtractFromDecodedImage performs OCR on an already decoded image using a pooled client
+func (e *Engine) ExtractFromDecodedImage(ctx context.Context, img image.Image, config *OCRConfig) (*OCRResult, error) {
+	src, err := decodedSource(img)
+	if err != nil {
+		return nil, err
+	}
+	return extract(ctx, e, src, config)
+}
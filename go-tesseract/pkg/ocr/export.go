@@ -0,0 +1,462 @@
+package ocr
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// Format is an output format for OCR results
+type Format string
+
+const (
+	FormatJSON Format = "json"
+	FormatHOCR Format = "hocr"
+	FormatALTO Format = "alto"
+	FormatPAGE Format = "page"
+)
+
+// softwareName identifies this package in exported files
+const softwareName = "tesseract_ocr_ne"
+
+// ParseFormat validates a format name coming from flags or form values
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case "":
+		return FormatJSON, nil
+	case FormatJSON, FormatHOCR, FormatALTO, FormatPAGE:
+		return f, nil
+	}
+	return FormatJSON, fmt.Errorf("unknown format %q (use json, hocr, alto or page)", s)
+}
+
+// ContentType is the MIME type of documents in the format
+func (f Format) ContentType() string {
+	switch f {
+	case FormatHOCR:
+		return "application/xhtml+xml; charset=utf-8"
+	case FormatALTO, FormatPAGE:
+		return "application/xml; charset=utf-8"
+	}
+	return "application/json"
+}
+
+// Extension is the usual file extension for the format
+func (f Format) Extension() string {
+	switch f {
+	case FormatHOCR:
+		return ".hocr"
+	case FormatALTO, FormatPAGE:
+		return ".xml"
+	}
+	return ".json"
+}
+
+// PageTrees returns the page tree of the result, or nil when no
+// Granularity was requested
+func (r *OCRResult) PageTrees() []*Page {
+	if r.Page == nil {
+		return nil
+	}
+	return []*Page{r.Page}
+}
+
+// PageTrees returns the page tree of every page in order
+func (d *DocumentResult) PageTrees() []*Page {
+	var pages []*Page
+	for _, p := range d.Pages {
+		if p.Page != nil {
+			pages = append(pages, p.Page)
+		}
+	}
+	return pages
+}
+
+// Export writes pages as hOCR, ALTO or PAGE XML. The pages come from
+// PageTrees of a result extracted with Granularity word, so that every word
+// has its box and confidence. imageName is recorded as the source image.
+// PAGE XML describes a single page, so it accepts exactly one
+func Export(w io.Writer, format Format, pages []*Page, imageName string) error {
+	if len(pages) == 0 {
+		return fmt.Errorf("nothing to export: extract with a granularity to get the page tree")
+	}
+
+	var doc any
+	switch format {
+	case FormatHOCR:
+		doc = hocrDocument(pages, imageName)
+	case FormatALTO:
+		doc = altoDocument(pages, imageName)
+	case FormatPAGE:
+		if len(pages) != 1 {
+			return fmt.Errorf("PAGE XML holds one page, got %d", len(pages))
+		}
+		doc = pageDocument(pages[0], imageName)
+	default:
+		return fmt.Errorf("format %q is not an export format (use hocr, alto or page)", format)
+	}
+
+	if format == FormatHOCR {
+		if _, err := io.WriteString(w, xml.Header+hocrDoctype); err != nil {
+			return err
+		}
+	} else if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", " ")
+	if err := enc.Encode(doc); err != nil {
+		return fmt.Errorf("failed to write %s: %w", format, err)
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// boxCorners returns the top-left and bottom-right corners of a box
+func boxCorners(b BoundingBox) (x0, y0, x1, y1 int) {
+	return b.X, b.Y, b.X + b.Width, b.Y + b.Height
+}
+
+// unitConfidence maps a 0-100 confidence onto 0-1 with two decimals
+func unitConfidence(conf float64) string {
+	return fmt.Sprintf("%.2f", math.Max(0, math.Min(conf, 100))/100)
+}
+
+// --- hOCR ---
+
+const hocrDoctype = `<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Transitional//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-transitional.dtd">` + "\n"
+
+type hocrHTML struct {
+	XMLName xml.Name    `xml:"html"`
+	Xmlns   string      `xml:"xmlns,attr"`
+	Head    hocrHead    `xml:"head"`
+	Body    []hocrBlock `xml:"body>div"`
+}
+
+type hocrHead struct {
+	Title string     `xml:"title"`
+	Meta  []hocrMeta `xml:"meta"`
+}
+
+type hocrMeta struct {
+	HTTPEquiv string `xml:"http-equiv,attr,omitempty"`
+	Name      string `xml:"name,attr,omitempty"`
+	Content   string `xml:"content,attr"`
+}
+
+// hocrBlock is any hOCR element: div, p or span with class, id and title
+type hocrBlock struct {
+	XMLName  xml.Name
+	Class    string      `xml:"class,attr"`
+	ID       string      `xml:"id,attr"`
+	Title    string      `xml:"title,attr"`
+	Text     string      `xml:",chardata"`
+	Children []hocrBlock `xml:",any"`
+}
+
+func hocrBBox(b BoundingBox) string {
+	x0, y0, x1, y1 := boxCorners(b)
+	return fmt.Sprintf("bbox %d %d %d %d", x0, y0, x1, y1)
+}
+
+func hocrDocument(pages []*Page, imageName string) hocrHTML {
+	doc := hocrHTML{
+		Xmlns: "http://www.w3.org/1999/xhtml",
+		Head: hocrHead{
+			Meta: []hocrMeta{
+				{HTTPEquiv: "Content-Type", Content: "text/html;charset=utf-8"},
+				{Name: "ocr-system", Content: softwareName},
+				{Name: "ocr-capabilities", Content: "ocr_page ocr_carea ocr_par ocr_line ocrx_word ocrp_wconf"},
+			},
+		},
+	}
+
+	for _, page := range pages {
+		p := page.Index + 1
+		pageDiv := hocrBlock{
+			XMLName: xml.Name{Local: "div"},
+			Class:   "ocr_page",
+			ID:      fmt.Sprintf("page_%d", p),
+			Title:   fmt.Sprintf("image %q; %s; ppageno %d", imageName, hocrBBox(page.Box), page.Index),
+		}
+		for bi, block := range page.Blocks {
+			blockDiv := hocrBlock{
+				XMLName: xml.Name{Local: "div"},
+				Class:   "ocr_carea",
+				ID:      fmt.Sprintf("block_%d_%d", p, bi+1),
+				Title:   hocrBBox(block.Box),
+			}
+			for pi, para := range block.Paragraphs {
+				paraP := hocrBlock{
+					XMLName: xml.Name{Local: "p"},
+					Class:   "ocr_par",
+					ID:      fmt.Sprintf("par_%d_%d_%d", p, bi+1, pi+1),
+					Title:   hocrBBox(para.Box),
+				}
+				for li, line := range para.Lines {
+					lineID := fmt.Sprintf("%d_%d_%d_%d", p, bi+1, pi+1, li+1)
+					lineSpan := hocrBlock{
+						XMLName: xml.Name{Local: "span"},
+						Class:   "ocr_line",
+						ID:      "line_" + lineID,
+						Title:   fmt.Sprintf("%s; x_wconf %.0f", hocrBBox(line.Box), line.Confidence),
+					}
+					// A line without a word tree still carries its text
+					if len(line.Words) == 0 {
+						lineSpan.Text = line.Text
+					}
+					for wi, word := range line.Words {
+						lineSpan.Children = append(lineSpan.Children, hocrBlock{
+							XMLName: xml.Name{Local: "span"},
+							Class:   "ocrx_word",
+							ID:      fmt.Sprintf("word_%s_%d", lineID, wi+1),
+							Title:   fmt.Sprintf("%s; x_wconf %.0f", hocrBBox(word.Box), word.Confidence),
+							Text:    word.Text,
+						})
+					}
+					paraP.Children = append(paraP.Children, lineSpan)
+				}
+				blockDiv.Children = append(blockDiv.Children, paraP)
+			}
+			pageDiv.Children = append(pageDiv.Children, blockDiv)
+		}
+		doc.Body = append(doc.Body, pageDiv)
+	}
+	return doc
+}
+
+// --- ALTO ---
+
+type altoDoc struct {
+	XMLName        xml.Name        `xml:"alto"`
+	Xmlns          string          `xml:"xmlns,attr"`
+	XmlnsXsi       string          `xml:"xmlns:xsi,attr"`
+	SchemaLocation string          `xml:"xsi:schemaLocation,attr"`
+	Description    altoDescription `xml:"Description"`
+	Pages          []altoPage      `xml:"Layout>Page"`
+}
+
+type altoDescription struct {
+	MeasurementUnit string `xml:"MeasurementUnit"`
+	FileName        string `xml:"sourceImageInformation>fileName"`
+	Software        string `xml:"OCRProcessing>ocrProcessingStep>processingSoftware>softwareName"`
+}
+
+type altoBox struct {
+	ID     string `xml:"ID,attr"`
+	HPos   int    `xml:"HPOS,attr"`
+	VPos   int    `xml:"VPOS,attr"`
+	Width  int    `xml:"WIDTH,attr"`
+	Height int    `xml:"HEIGHT,attr"`
+}
+
+type altoPage struct {
+	ID         string         `xml:"ID,attr"`
+	PhysicalNr int            `xml:"PHYSICAL_IMG_NR,attr"`
+	Width      int            `xml:"WIDTH,attr"`
+	Height     int            `xml:"HEIGHT,attr"`
+	PrintSpace altoPrintSpace `xml:"PrintSpace"`
+}
+
+type altoPrintSpace struct {
+	altoBox
+	TextBlocks []altoTextBlock `xml:"TextBlock"`
+}
+
+type altoTextBlock struct {
+	altoBox
+	Lines []altoLine `xml:"TextLine"`
+}
+
+type altoLine struct {
+	altoBox
+	Items []any `xml:",any"`
+}
+
+type altoString struct {
+	XMLName xml.Name `xml:"String"`
+	altoBox
+	Content string `xml:"CONTENT,attr"`
+	WC      string `xml:"WC,attr"`
+}
+
+type altoSpace struct {
+	XMLName xml.Name `xml:"SP"`
+}
+
+func newAltoBox(id string, b BoundingBox) altoBox {
+	return altoBox{ID: id, HPos: b.X, VPos: b.Y, Width: b.Width, Height: b.Height}
+}
+
+func altoDocument(pages []*Page, imageName string) altoDoc {
+	doc := altoDoc{
+		Xmlns:          "http://www.loc.gov/standards/alto/ns-v4#",
+		XmlnsXsi:       "http://www.w3.org/2001/XMLSchema-instance",
+		SchemaLocation: "http://www.loc.gov/standards/alto/ns-v4# http://www.loc.gov/alto/v4/alto-4-2.xsd",
+		Description: altoDescription{
+			MeasurementUnit: "pixel",
+			FileName:        imageName,
+			Software:        softwareName,
+		},
+	}
+
+	for _, page := range pages {
+		p := page.Index + 1
+		ap := altoPage{
+			ID:         fmt.Sprintf("page_%d", p),
+			PhysicalNr: p,
+			Width:      page.Box.Width,
+			Height:     page.Box.Height,
+		}
+		var content BoundingBox
+		first := true
+		for bi, block := range page.Blocks {
+			tb := altoTextBlock{altoBox: newAltoBox(fmt.Sprintf("block_%d_%d", p, bi+1), block.Box)}
+			if first {
+				content, first = block.Box, false
+			} else {
+				content = newBoundingBox(content.Rect().Union(block.Box.Rect()))
+			}
+			// ALTO has no paragraph level; lines of all paragraphs go in the block
+			li := 0
+			for _, para := range block.Paragraphs {
+				for _, line := range para.Lines {
+					li++
+					lineID := fmt.Sprintf("%d_%d_%d", p, bi+1, li)
+					al := altoLine{altoBox: newAltoBox("line_"+lineID, line.Box)}
+					for wi, word := range line.Words {
+						if wi > 0 {
+							al.Items = append(al.Items, altoSpace{})
+						}
+						al.Items = append(al.Items, altoString{
+							altoBox: newAltoBox(fmt.Sprintf("string_%s_%d", lineID, wi+1), word.Box),
+							Content: word.Text,
+							WC:      unitConfidence(word.Confidence),
+						})
+					}
+					if len(line.Words) == 0 {
+						al.Items = append(al.Items, altoString{
+							altoBox: newAltoBox(fmt.Sprintf("string_%s_1", lineID), line.Box),
+							Content: line.Text,
+							WC:      unitConfidence(line.Confidence),
+						})
+					}
+					tb.Lines = append(tb.Lines, al)
+				}
+			}
+			ap.PrintSpace.TextBlocks = append(ap.PrintSpace.TextBlocks, tb)
+		}
+		ap.PrintSpace.altoBox = newAltoBox(fmt.Sprintf("printspace_%d", p), content)
+		doc.Pages = append(doc.Pages, ap)
+	}
+	return doc
+}
+
+// --- PAGE XML ---
+
+type pageGts struct {
+	XMLName        xml.Name     `xml:"PcGts"`
+	Xmlns          string       `xml:"xmlns,attr"`
+	XmlnsXsi       string       `xml:"xmlns:xsi,attr"`
+	SchemaLocation string       `xml:"xsi:schemaLocation,attr"`
+	Metadata       pageMetadata `xml:"Metadata"`
+	Page           pagePage     `xml:"Page"`
+}
+
+type pageMetadata struct {
+	Creator    string `xml:"Creator"`
+	Created    string `xml:"Created"`
+	LastChange string `xml:"LastChange"`
+}
+
+type pagePage struct {
+	ImageFilename string       `xml:"imageFilename,attr"`
+	ImageWidth    int          `xml:"imageWidth,attr"`
+	ImageHeight   int          `xml:"imageHeight,attr"`
+	Regions       []pageRegion `xml:"TextRegion"`
+}
+
+type pageCoords struct {
+	Points string `xml:"points,attr"`
+}
+
+type pageTextEquiv struct {
+	Conf    string `xml:"conf,attr,omitempty"`
+	Unicode string `xml:"Unicode"`
+}
+
+type pageRegion struct {
+	ID        string        `xml:"id,attr"`
+	Coords    pageCoords    `xml:"Coords"`
+	Lines     []pageLine    `xml:"TextLine"`
+	TextEquiv pageTextEquiv `xml:"TextEquiv"`
+}
+
+type pageLine struct {
+	ID        string        `xml:"id,attr"`
+	Coords    pageCoords    `xml:"Coords"`
+	Words     []pageWord    `xml:"Word"`
+	TextEquiv pageTextEquiv `xml:"TextEquiv"`
+}
+
+type pageWord struct {
+	ID        string        `xml:"id,attr"`
+	Coords    pageCoords    `xml:"Coords"`
+	TextEquiv pageTextEquiv `xml:"TextEquiv"`
+}
+
+// pagePoints is a box as a PAGE polygon, clockwise from the top-left corner
+func pagePoints(b BoundingBox) pageCoords {
+	x0, y0, x1, y1 := boxCorners(b)
+	return pageCoords{Points: fmt.Sprintf("%d,%d %d,%d %d,%d %d,%d", x0, y0, x1, y0, x1, y1, x0, y1)}
+}
+
+func pageDocument(page *Page, imageName string) pageGts {
+	now := time.Now().UTC().Format("2006-01-02T15:04:05")
+	doc := pageGts{
+		Xmlns:          "http://schema.primaresearch.org/PAGE/gts/pagecontent/2019-07-15",
+		XmlnsXsi:       "http://www.w3.org/2001/XMLSchema-instance",
+		SchemaLocation: "http://schema.primaresearch.org/PAGE/gts/pagecontent/2019-07-15 http://schema.primaresearch.org/PAGE/gts/pagecontent/2019-07-15/pagecontent.xsd",
+		Metadata:       pageMetadata{Creator: softwareName, Created: now, LastChange: now},
+		Page: pagePage{
+			ImageFilename: imageName,
+			ImageWidth:    page.Box.Width,
+			ImageHeight:   page.Box.Height,
+		},
+	}
+
+	// PAGE regions are blocks; paragraphs are flattened into their lines
+	for bi, block := range page.Blocks {
+		region := pageRegion{
+			ID:        fmt.Sprintf("r%d", bi+1),
+			Coords:    pagePoints(block.Box),
+			TextEquiv: pageTextEquiv{Unicode: block.Text},
+		}
+		li := 0
+		for _, para := range block.Paragraphs {
+			for _, line := range para.Lines {
+				li++
+				lineID := fmt.Sprintf("r%dl%d", bi+1, li)
+				pl := pageLine{
+					ID:        lineID,
+					Coords:    pagePoints(line.Box),
+					TextEquiv: pageTextEquiv{Conf: unitConfidence(line.Confidence), Unicode: line.Text},
+				}
+				for wi, word := range line.Words {
+					pl.Words = append(pl.Words, pageWord{
+						ID:        fmt.Sprintf("%sw%d", lineID, wi+1),
+						Coords:    pagePoints(word.Box),
+						TextEquiv: pageTextEquiv{Conf: unitConfidence(word.Confidence), Unicode: word.Text},
+					})
+				}
+				region.Lines = append(region.Lines, pl)
+			}
+		}
+		doc.Page.Regions = append(doc.Page.Regions, region)
+	}
+	return doc
+}
@@ -0,0 +1,79 @@
+package ocr
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"sort"
+
+	"github.com/otiai10/gosseract/v2"
+)
+
+// Versions names the OCR libraries the program is linked against
+type Versions struct {
+	Tesseract string `json:"tesseract"`
+	Leptonica string `json:"leptonica,omitempty"`
+}
+
+// LibraryVersions returns the versions of the Tesseract and Leptonica
+// libraries linked into the program, which need not be those of any
+// tesseract command installed next to it
+func LibraryVersions() Versions {
+	return Versions{Tesseract: gosseract.Version(), Leptonica: leptonicaVersion()}
+}
+
+// AvailableLanguages lists the traineddata files Tesseract can load, sorted
+func AvailableLanguages() ([]string, error) {
+	langs, err := gosseract.GetAvailableLanguages()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tessdata: %w", err)
+	}
+	sort.Strings(langs)
+	return langs, nil
+}
+
+// blankImage is a small white PNG recognized by CheckLanguage
+var blankImage = func() []byte {
+	img := image.NewGray(image.Rect(0, 0, 32, 32))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	return buf.Bytes()
+}()
+
+// CheckLanguage initializes a fresh Tesseract client with language (such
+// as "nep" or "nep+eng") and recognizes a blank image, which fails when
+// the traineddata is missing or broken. Pooled clients are not touched
+func CheckLanguage(ctx context.Context, language string) error {
+	client, err := newClient(poolKey{language: language})
+	if err != nil {
+		return err
+	}
+
+	// Loading traineddata cannot be interrupted, so a check that outlives
+	// ctx is left to finish on its own
+	done := make(chan error, 1)
+	go func() {
+		defer client.Close()
+		if err := client.SetImageFromBytes(blankImage); err != nil {
+			done <- err
+			return
+		}
+		_, err := client.Text()
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("tesseract cannot load %s: %w", language, err)
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("tesseract did not load %s in time: %w", language, ctx.Err())
+	}
+}
@@ -0,0 +1,249 @@
+package ocr
+
+import (
+	"fmt"
+	"image"
+	"strings"
+
+	"github.com/otiai10/gosseract/v2"
+)
+
+// extractPageLevel runs OCR once and returns the full page tree built
+// from Tesseract's word boxes (which carry block/paragraph/line numbers)
+func extractPageLevel(client *gosseract.Client, bounds image.Rectangle) (*Page, error) {
+	boxes, err := client.GetBoundingBoxesVerbose()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get bounding boxes: %w", err)
+	}
+
+	return buildPage(boxes, bounds), nil
+}
+
+// buildPage groups verbose word boxes into blocks, paragraphs and lines.
+// Tesseract numbers restart inside their parent, so a change in any
+// number at or above a level opens a new node at that level
+func buildPage(boxes []gosseract.BoundingBox, bounds image.Rectangle) *Page {
+	page := &Page{Box: newBoundingBox(bounds)}
+
+	var block *Block
+	var para *Paragraph
+	var line *Line
+	blockNum, parNum, lineNum := -1, -1, -1
+
+	for _, b := range boxes {
+		text := strings.TrimSpace(b.Word)
+		if text == "" {
+			continue
+		}
+
+		if block == nil || b.BlockNum != blockNum {
+			page.Blocks = append(page.Blocks, Block{})
+			block = &page.Blocks[len(page.Blocks)-1]
+			blockNum, parNum, lineNum = b.BlockNum, -1, -1
+		}
+		if b.ParNum != parNum {
+			block.Paragraphs = append(block.Paragraphs, Paragraph{})
+			para = &block.Paragraphs[len(block.Paragraphs)-1]
+			parNum, lineNum = b.ParNum, -1
+		}
+		if b.LineNum != lineNum {
+			para.Lines = append(para.Lines, Line{})
+			line = &para.Lines[len(para.Lines)-1]
+			lineNum = b.LineNum
+		}
+
+		line.Words = append(line.Words, Word{
+			Text:       text,
+			Confidence: b.Confidence,
+			Box:        newBoundingBox(b.Box),
+		})
+	}
+
+	summarizePage(page)
+	return page
+}
+
+// filterPage returns a copy of the tree with the word-level cleaning and
+// confidence rules applied. Nodes left without words are dropped and all
+// aggregates (text, confidence, boxes, indexes) are recomputed
+func filterPage(page *Page, config *OCRConfig) *Page {
+	out := &Page{Index: page.Index, Box: page.Box}
+	filter := config.textFilter()
+
+	for _, block := range page.Blocks {
+		var paras []Paragraph
+		for _, para := range block.Paragraphs {
+			var lines []Line
+			for _, line := range para.Lines {
+				var words []Word
+				for _, word := range line.Words {
+					if config.MinConfidence > 0 && word.Confidence < config.MinConfidence {
+						continue
+					}
+					text := word.Text
+					if filter != nil {
+						text = filter.Filter(text)
+					}
+					if text == "" {
+						continue
+					}
+					if config.Spell.Lexicon != nil {
+						text, _ = config.Spell.correctText(text)
+					}
+					word.Text = text
+					word.Script = DetectScript(text)
+					words = append(words, word)
+				}
+				if len(words) == 0 || !config.keepText(joinWords(words)) {
+					continue
+				}
+				lines = append(lines, Line{Words: words})
+			}
+			if len(lines) > 0 {
+				paras = append(paras, Paragraph{Lines: lines})
+			}
+		}
+		if len(paras) > 0 {
+			out.Blocks = append(out.Blocks, Block{Paragraphs: paras})
+		}
+	}
+
+	summarizePage(out)
+	return out
+}
+
+// truncatePage drops every level below the requested granularity
+func truncatePage(page *Page, g Granularity) {
+	if g == GranularityPage {
+		page.Blocks = nil
+		return
+	}
+	for bi := range page.Blocks {
+		block := &page.Blocks[bi]
+		if g == GranularityBlock {
+			block.Paragraphs = nil
+			continue
+		}
+		for pi := range block.Paragraphs {
+			para := &block.Paragraphs[pi]
+			if g == GranularityParagraph {
+				para.Lines = nil
+				continue
+			}
+			for li := range para.Lines {
+				if g == GranularityLine {
+					para.Lines[li].Words = nil
+				}
+			}
+		}
+	}
+}
+
+// pageLines flattens the tree into the line list used by the flat result
+func pageLines(page *Page) []ExtractedLine {
+	var lines []ExtractedLine
+	for _, block := range page.Blocks {
+		for _, para := range block.Paragraphs {
+			for _, line := range para.Lines {
+				lines = append(lines, ExtractedLine{
+					Text:       line.Text,
+					Confidence: line.Confidence,
+					Box:        line.Box,
+				})
+			}
+		}
+	}
+	return lines
+}
+
+// summarizePage fills text, confidence, box and index of every container
+// from its children. Confidence is the mean over all contained words
+func summarizePage(page *Page) {
+	var blockTexts []string
+	var pageConf float64
+	var pageWords int
+	var pageBox image.Rectangle
+
+	for bi := range page.Blocks {
+		block := &page.Blocks[bi]
+		var paraTexts []string
+		var blockConf float64
+		var blockWords int
+		var blockBox image.Rectangle
+
+		for pi := range block.Paragraphs {
+			para := &block.Paragraphs[pi]
+			var lineTexts []string
+			var paraConf float64
+			var paraWords int
+			var paraBox image.Rectangle
+
+			for li := range para.Lines {
+				line := &para.Lines[li]
+				var lineConf float64
+				var lineBox image.Rectangle
+
+				for wi := range line.Words {
+					word := &line.Words[wi]
+					word.Index = wi
+					lineConf += word.Confidence
+					lineBox = lineBox.Union(word.Box.Rect())
+				}
+
+				line.Index = li
+				line.Text = joinWords(line.Words)
+				line.Script = DetectScript(line.Text)
+				line.Box = newBoundingBox(lineBox)
+				if len(line.Words) > 0 {
+					line.Confidence = lineConf / float64(len(line.Words))
+				}
+
+				lineTexts = append(lineTexts, line.Text)
+				paraConf += lineConf
+				paraWords += len(line.Words)
+				paraBox = paraBox.Union(lineBox)
+			}
+
+			para.Index = pi
+			para.Text = strings.Join(lineTexts, "\n")
+			para.Box = newBoundingBox(paraBox)
+			para.Confidence = mean(paraConf, paraWords)
+
+			paraTexts = append(paraTexts, para.Text)
+			blockConf += paraConf
+			blockWords += paraWords
+			blockBox = blockBox.Union(paraBox)
+		}
+
+		block.Index = bi
+		block.Text = strings.Join(paraTexts, "\n\n")
+		block.Box = newBoundingBox(blockBox)
+		block.Confidence = mean(blockConf, blockWords)
+
+		blockTexts = append(blockTexts, block.Text)
+		pageConf += blockConf
+		pageWords += blockWords
+		pageBox = pageBox.Union(blockBox)
+	}
+
+	page.Text = strings.Join(blockTexts, "\n\n")
+	page.Confidence = mean(pageConf, pageWords)
+	if page.Box.Width == 0 || page.Box.Height == 0 {
+		page.Box = newBoundingBox(pageBox)
+	}
+}
+
+func joinWords(words []Word) string {
+	texts := make([]string, len(words))
+	for i, w := range words {
+		texts[i] = w.Text
+	}
+	return strings.Join(texts, " ")
+}
+
+func mean(total float64, count int) float64 {
+	if count == 0 {
+		return 0
+	}
+	return total / float64(count)
+}
@@ -0,0 +1,302 @@
+package ocr
+
+import (
+	"image"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// Image operations used by the preprocessing stage. They all work on
+// 8-bit grayscale where 0 is ink and 255 is paper
+
+// toGray converts any image to grayscale with a zero origin
+func toGray(img image.Image) *image.Gray {
+	b := img.Bounds()
+	gray := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(gray, gray.Bounds(), img, b.Min, draw.Src)
+	return gray
+}
+
+// otsuThreshold picks the threshold that maximizes between-class variance
+func otsuThreshold(g *image.Gray) uint8 {
+	var hist [256]int
+	for _, v := range g.Pix {
+		hist[v]++
+	}
+
+	total := len(g.Pix)
+	var sum float64
+	for i, n := range hist {
+		sum += float64(i * n)
+	}
+
+	var sumB, best float64
+	var weightB int
+	threshold := 127
+	for t, n := range hist {
+		weightB += n
+		if weightB == 0 {
+			continue
+		}
+		weightF := total - weightB
+		if weightF == 0 {
+			break
+		}
+		sumB += float64(t * n)
+		meanB := sumB / float64(weightB)
+		meanF := (sum - sumB) / float64(weightF)
+		between := float64(weightB) * float64(weightF) * (meanB - meanF) * (meanB - meanF)
+		if between > best {
+			best = between
+			threshold = t
+		}
+	}
+	return uint8(threshold)
+}
+
+// binarizeOtsu maps every pixel to black or white around the global Otsu threshold
+func binarizeOtsu(g *image.Gray) *image.Gray {
+	t := otsuThreshold(g)
+	out := image.NewGray(g.Bounds())
+	for i, v := range g.Pix {
+		if v > t {
+			out.Pix[i] = 255
+		}
+	}
+	return out
+}
+
+// binarizeSauvola thresholds each pixel against the mean and standard
+// deviation of its window: T = m * (1 + k * (s/128 - 1)). It copes with
+// uneven lighting and stains far better than a global threshold
+func binarizeSauvola(g *image.Gray, window int, k float64) *image.Gray {
+	w, h := g.Bounds().Dx(), g.Bounds().Dy()
+	half := window / 2
+
+	// Integral images of the values and their squares, padded by one row and column
+	stride := w + 1
+	sum := make([]float64, stride*(h+1))
+	sq := make([]float64, stride*(h+1))
+	for y := 0; y < h; y++ {
+		var rowSum, rowSq float64
+		for x := 0; x < w; x++ {
+			v := float64(g.Pix[y*g.Stride+x])
+			rowSum += v
+			rowSq += v * v
+			sum[(y+1)*stride+x+1] = sum[y*stride+x+1] + rowSum
+			sq[(y+1)*stride+x+1] = sq[y*stride+x+1] + rowSq
+		}
+	}
+
+	out := image.NewGray(g.Bounds())
+	for y := 0; y < h; y++ {
+		y0, y1 := max(y-half, 0), min(y+half+1, h)
+		for x := 0; x < w; x++ {
+			x0, x1 := max(x-half, 0), min(x+half+1, w)
+			n := float64((x1 - x0) * (y1 - y0))
+			s := sum[y1*stride+x1] - sum[y0*stride+x1] - sum[y1*stride+x0] + sum[y0*stride+x0]
+			s2 := sq[y1*stride+x1] - sq[y0*stride+x1] - sq[y1*stride+x0] + sq[y0*stride+x0]
+			m := s / n
+			sd := math.Sqrt(math.Max(s2/n-m*m, 0))
+			t := m * (1 + k*(sd/128-1))
+			if float64(g.Pix[y*g.Stride+x]) > t {
+				out.Pix[y*out.Stride+x] = 255
+			}
+		}
+	}
+	return out
+}
+
+// medianFilter replaces each pixel by the median of its 3x3 neighbourhood,
+// removing salt-and-pepper specks without blurring stroke edges much
+func medianFilter(g *image.Gray) *image.Gray {
+	w, h := g.Bounds().Dx(), g.Bounds().Dy()
+	out := image.NewGray(g.Bounds())
+	var window [9]uint8
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			n := 0
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					// Clamp at the edges so border pixels still see nine samples
+					sx := min(max(x+dx, 0), w-1)
+					sy := min(max(y+dy, 0), h-1)
+					window[n] = g.Pix[sy*g.Stride+sx]
+					n++
+				}
+			}
+			// Insertion sort; nine values are too few for anything smarter
+			for i := 1; i < len(window); i++ {
+				for j := i; j > 0 && window[j] < window[j-1]; j-- {
+					window[j], window[j-1] = window[j-1], window[j]
+				}
+			}
+			out.Pix[y*out.Stride+x] = window[4]
+		}
+	}
+	return out
+}
+
+// removeBorder whitens dark bands along the edges left by scanner lids and
+// photocopies. A row or column counts as border while more than half of it
+// is ink; the page keeps its size so box coordinates stay valid
+func removeBorder(g *image.Gray) *image.Gray {
+	w, h := g.Bounds().Dx(), g.Bounds().Dy()
+	t := otsuThreshold(g)
+	out := image.NewGray(g.Bounds())
+	copy(out.Pix, g.Pix)
+
+	rowDark := func(y int) bool {
+		n := 0
+		for x := 0; x < w; x++ {
+			if g.Pix[y*g.Stride+x] <= t {
+				n++
+			}
+		}
+		return n*2 > w
+	}
+	colDark := func(x int) bool {
+		n := 0
+		for y := 0; y < h; y++ {
+			if g.Pix[y*g.Stride+x] <= t {
+				n++
+			}
+		}
+		return n*2 > h
+	}
+	fillRow := func(y int) {
+		for x := 0; x < w; x++ {
+			out.Pix[y*out.Stride+x] = 255
+		}
+	}
+	fillCol := func(x int) {
+		for y := 0; y < h; y++ {
+			out.Pix[y*out.Stride+x] = 255
+		}
+	}
+
+	for y := 0; y < h/4 && rowDark(y); y++ {
+		fillRow(y)
+	}
+	for y := h - 1; y >= h*3/4 && rowDark(y); y-- {
+		fillRow(y)
+	}
+	for x := 0; x < w/4 && colDark(x); x++ {
+		fillCol(x)
+	}
+	for x := w - 1; x >= w*3/4 && colDark(x); x-- {
+		fillCol(x)
+	}
+	return out
+}
+
+// maxSkewSamples caps the ink pixels scored per angle to keep deskew fast on large scans
+const maxSkewSamples = 200000
+
+// detectSkew finds the angle in degrees for which rotateGray levels the
+// text. Text lines produce tall narrow peaks in the horizontal projection
+// profile only when they are level, so the angle with the highest sum of
+// squared differences between neighbouring rows wins
+func detectSkew(g *image.Gray, maxAngle float64) float64 {
+	w, h := g.Bounds().Dx(), g.Bounds().Dy()
+	t := otsuThreshold(g)
+
+	// Count the ink first so only every step-th pixel is kept, rather than
+	// collecting tens of millions of points on a dense page
+	ink := 0
+	for y := 0; y < h; y++ {
+		for _, v := range g.Pix[y*g.Stride : y*g.Stride+w] {
+			if v <= t {
+				ink++
+			}
+		}
+	}
+	if ink == 0 {
+		return 0
+	}
+	step := max(ink/maxSkewSamples, 1)
+
+	points := make([]image.Point, 0, ink/step+1)
+	seen := 0
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if g.Pix[y*g.Stride+x] <= t {
+				if seen%step == 0 {
+					points = append(points, image.Point{X: x, Y: y})
+				}
+				seen++
+			}
+		}
+	}
+
+	score := func(angle float64) float64 {
+		rad := angle * math.Pi / 180
+		sin, cos := math.Sin(rad), math.Cos(rad)
+		// Rows of the rotated image can fall up to w*|sin| outside [0, h)
+		offset := int(float64(w)*math.Abs(sin)) + 1
+		bins := make([]int, h+2*offset)
+		for _, p := range points {
+			y := int(float64(p.Y)*cos-float64(p.X)*sin) + offset
+			if y >= 0 && y < len(bins) {
+				bins[y]++
+			}
+		}
+		var s float64
+		for i := 1; i < len(bins); i++ {
+			d := float64(bins[i] - bins[i-1])
+			s += d * d
+		}
+		return s
+	}
+
+	// Coarse search in half degrees, then refine around the best in tenths
+	best, bestScore := 0.0, score(0)
+	for a := -maxAngle; a <= maxAngle; a += 0.5 {
+		if s := score(a); s > bestScore {
+			best, bestScore = a, s
+		}
+	}
+	center := best
+	for a := center - 0.5; a <= center+0.5; a += 0.1 {
+		if s := score(a); s > bestScore {
+			best, bestScore = a, s
+		}
+	}
+	return math.Round(best*10) / 10
+}
+
+// rotateGray rotates the image by angle degrees around its center, keeping
+// its size and filling uncovered areas with white
+func rotateGray(g *image.Gray, angle float64) *image.Gray {
+	w, h := g.Bounds().Dx(), g.Bounds().Dy()
+	rad := angle * math.Pi / 180
+	sin, cos := math.Sin(rad), math.Cos(rad)
+	cx, cy := float64(w)/2, float64(h)/2
+
+	out := image.NewGray(g.Bounds())
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			// Inverse mapping: find the source pixel that lands on (x, y)
+			dx, dy := float64(x)-cx, float64(y)-cy
+			sx := int(math.Round(dx*cos - dy*sin + cx))
+			sy := int(math.Round(dx*sin + dy*cos + cy))
+			if sx < 0 || sy < 0 || sx >= w || sy >= h {
+				out.Pix[y*out.Stride+x] = 255
+				continue
+			}
+			out.Pix[y*out.Stride+x] = g.Pix[sy*g.Stride+sx]
+		}
+	}
+	return out
+}
+
+// scaleGray resizes the image by factor with Catmull-Rom interpolation
+func scaleGray(g *image.Gray, factor float64) *image.Gray {
+	w := int(math.Round(float64(g.Bounds().Dx()) * factor))
+	h := int(math.Round(float64(g.Bounds().Dy()) * factor))
+	out := image.NewGray(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(out, out.Bounds(), g, g.Bounds(), draw.Src, nil)
+	return out
+}
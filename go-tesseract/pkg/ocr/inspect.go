@@ -0,0 +1,159 @@
+package ocr
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+
+	// Register the formats Inspect and the decoders accept besides PNG, JPEG and TIFF
+	_ "image/gif"
+
+	_ "golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+var (
+	// ErrUnsupportedFormat is returned by Inspect for content that is no
+	// supported image or PDF, whatever its file name says
+	ErrUnsupportedFormat = errors.New("unsupported format")
+	// ErrImageTooLarge is returned by Inspect for images over ImageLimits,
+	// and by extraction for pages over OCRConfig.ImageLimits
+	ErrImageTooLarge = errors.New("image too large")
+	// ErrCorruptImage is returned by Inspect for images whose header does
+	// not decode, and by extraction for images whose pixels do not
+	ErrCorruptImage = errors.New("corrupt image")
+)
+
+// ImageLimits bounds the images Inspect and OCRConfig accept. Zero means no limit
+type ImageLimits struct {
+	// MaxSide caps the width and the height
+	MaxSide int
+	// MaxPixels caps width times height, so a small file cannot decode
+	// into gigabytes of pixels
+	MaxPixels int
+}
+
+// InputInfo is what Inspect found out about a file
+type InputInfo struct {
+	// Format is "png", "jpeg", "tiff", "webp", "bmp", "gif" or "pdf"
+	Format string `json:"format"`
+	// Width and Height are those of the largest page; zero for PDFs,
+	// whose pages are only sized when they are rasterized
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+	Pages  int `json:"pages"`
+}
+
+// DetectFormat names the format of data from its magic bytes, in the
+// words of image.Decode, or "pdf". It returns "" for anything else
+func DetectFormat(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return "png"
+	case bytes.HasPrefix(data, []byte("\xff\xd8\xff")):
+		return "jpeg"
+	case IsTIFF(data):
+		return "tiff"
+	case len(data) >= 12 && bytes.HasPrefix(data, []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WEBP")):
+		return "webp"
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return "gif"
+	case isBMP(data):
+		return "bmp"
+	case isPDF(data):
+		return "pdf"
+	}
+	return ""
+}
+
+// isBMP checks the two byte "BM" signature together with the size of the
+// header that follows it, which is one of a few known values
+func isBMP(data []byte) bool {
+	if len(data) < 18 || !bytes.HasPrefix(data, []byte("BM")) {
+		return false
+	}
+	switch binary.LittleEndian.Uint32(data[14:18]) {
+	case 12, 40, 52, 56, 108, 124:
+		return true
+	}
+	return false
+}
+
+// isPDF looks for the header in the first KB, where readers accept it
+func isPDF(data []byte) bool {
+	return bytes.Contains(data[:min(len(data), 1024)], []byte("%PDF-"))
+}
+
+// Inspect sniffs the format of data and checks that it can be recognized:
+// the header of an image, or of every page of a TIFF, must decode and stay
+// within limits. Only headers are read, so Inspect is cheap to run on every
+// upload; damaged pixels are found when the image is decoded for OCR with
+// OCRConfig.VerifyImage. PDFs are only sniffed, their pages are checked
+// against OCRConfig.ImageLimits at extraction. The errors wrap
+// ErrUnsupportedFormat, ErrImageTooLarge or ErrCorruptImage
+func Inspect(data []byte, limits ImageLimits) (*InputInfo, error) {
+	info := &InputInfo{Format: DetectFormat(data), Pages: 1}
+	switch info.Format {
+	case "":
+		return nil, ErrUnsupportedFormat
+	case "pdf":
+		info.Pages = 0
+		return info, nil
+	case "tiff":
+		if err := inspectTIFF(data, info, limits); err != nil {
+			return nil, err
+		}
+		return info, nil
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptImage, err)
+	}
+	if err := limits.check(cfg.Width, cfg.Height); err != nil {
+		return nil, err
+	}
+	info.Width, info.Height = cfg.Width, cfg.Height
+	return info, nil
+}
+
+// inspectTIFF reads the header of every frame, the same way extractTIFF
+// points the decoder at them
+func inspectTIFF(data []byte, info *InputInfo, limits ImageLimits) error {
+	order, offsets, err := tiffFrames(data)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCorruptImage, err)
+	}
+
+	buf := make([]byte, len(data))
+	copy(buf, data)
+	for i, offset := range offsets {
+		order.PutUint32(buf[4:8], offset)
+		cfg, err := tiff.DecodeConfig(bytes.NewReader(buf))
+		if err != nil {
+			return fmt.Errorf("%w: tiff page %d: %v", ErrCorruptImage, i+1, err)
+		}
+		if err := limits.check(cfg.Width, cfg.Height); err != nil {
+			return fmt.Errorf("page %d: %w", i+1, err)
+		}
+		if int64(cfg.Width)*int64(cfg.Height) > int64(info.Width)*int64(info.Height) {
+			info.Width, info.Height = cfg.Width, cfg.Height
+		}
+	}
+	info.Pages = len(offsets)
+	return nil
+}
+
+// check fails for images over the limits
+func (l ImageLimits) check(width, height int) error {
+	if width <= 0 || height <= 0 {
+		return fmt.Errorf("%w: image is %dx%d pixels", ErrCorruptImage, width, height)
+	}
+	if (l.MaxSide > 0 && max(width, height) > l.MaxSide) || (l.MaxPixels > 0 && int64(width)*int64(height) > int64(l.MaxPixels)) {
+		return fmt.Errorf("%w: %dx%d pixels", ErrImageTooLarge, width, height)
+	}
+	return nil
+}
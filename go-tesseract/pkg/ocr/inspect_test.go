@@ -0,0 +1,199 @@
+package ocr
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/gif"
+	"image/png"
+	"testing"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+)
+
+// testImage is a blank w x h grayscale page
+func testImage(w, h int) image.Image {
+	return image.NewGray(image.Rect(0, 0, w, h))
+}
+
+func encodePNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage(w, h)); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodeGIF(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := gif.Encode(&buf, testImage(w, h), nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodeBMP(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := bmp.Encode(&buf, testImage(w, h)); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodeTIFF(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := tiff.Encode(&buf, testImage(w, h), nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// encodeWebP writes the header of a lossless WebP, enough for DecodeConfig;
+// Go has no WebP encoder
+func encodeWebP(w, h int) []byte {
+	bits := uint32(w-1) | uint32(h-1)<<14
+	chunk := []byte{0x2f, byte(bits), byte(bits >> 8), byte(bits >> 16), byte(bits >> 24), 0}
+	data := []byte("RIFF\x00\x00\x00\x00WEBPVP8L\x05\x00\x00\x00")
+	data = append(data, chunk...)
+	binary.LittleEndian.PutUint32(data[4:8], uint32(len(data)-8))
+	return data
+}
+
+// pngWithSize rewrites the IHDR of a PNG to claim w x h pixels, as a
+// decompression bomb would
+func pngWithSize(t *testing.T, w, h int) []byte {
+	t.Helper()
+	data := encodePNG(t, 1, 1)
+	// Signature, then the IHDR length, type and data
+	ihdr := data[12 : 12+4+13]
+	binary.BigEndian.PutUint32(ihdr[4:8], uint32(w))
+	binary.BigEndian.PutUint32(ihdr[8:12], uint32(h))
+	binary.BigEndian.PutUint32(data[12+4+13:], crc32.ChecksumIEEE(ihdr))
+	return data
+}
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"png", encodePNG(t, 2, 2), "png"},
+		{"jpeg", []byte("\xff\xd8\xff\xe0\x00\x10JFIF"), "jpeg"},
+		{"little endian tiff", []byte("II*\x00\x08\x00\x00\x00"), "tiff"},
+		{"big endian tiff", []byte("MM\x00*\x00\x00\x00\x08"), "tiff"},
+		{"webp", encodeWebP(2, 2), "webp"},
+		{"riff that is not webp", []byte("RIFF\x24\x00\x00\x00WAVEfmt "), ""},
+		{"gif87a", []byte("GIF87a\x02\x00\x02\x00"), "gif"},
+		{"gif89a", encodeGIF(t, 2, 2), "gif"},
+		{"bmp", encodeBMP(t, 2, 2), "bmp"},
+		{"BM without a bmp header", []byte("BM is not an image at all"), ""},
+		{"pdf", []byte("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n"), "pdf"},
+		{"pdf after junk", append(bytes.Repeat([]byte{' '}, 100), "%PDF-1.4"...), "pdf"},
+		{"pdf header too late", append(bytes.Repeat([]byte{' '}, 1024), "%PDF-1.4"...), ""},
+		{"text", []byte("just some text"), ""},
+		{"empty", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectFormat(tt.data); got != tt.want {
+				t.Errorf("DetectFormat() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInspect(t *testing.T) {
+	limits := ImageLimits{MaxSide: 1000, MaxPixels: 500_000}
+	valid := encodePNG(t, 30, 20)
+
+	tests := []struct {
+		name   string
+		data   []byte
+		format string
+		width  int
+		height int
+		pages  int
+		err    error
+	}{
+		{name: "png", data: valid, format: "png", width: 30, height: 20, pages: 1},
+		{name: "gif", data: encodeGIF(t, 30, 20), format: "gif", width: 30, height: 20, pages: 1},
+		{name: "bmp", data: encodeBMP(t, 30, 20), format: "bmp", width: 30, height: 20, pages: 1},
+		{name: "webp", data: encodeWebP(30, 20), format: "webp", width: 30, height: 20, pages: 1},
+		{name: "tiff", data: encodeTIFF(t, 30, 20), format: "tiff", width: 30, height: 20, pages: 1},
+		{name: "pdf renamed to png", data: []byte("%PDF-1.4\n1 0 obj\n"), format: "pdf", pages: 0},
+		{name: "text renamed to png", data: []byte("hello"), err: ErrUnsupportedFormat},
+		{name: "truncated png", data: valid[:20], err: ErrCorruptImage},
+		{name: "header over the side limit", data: pngWithSize(t, 1001, 10), err: ErrImageTooLarge},
+		{name: "header over the pixel limit", data: pngWithSize(t, 1000, 501), err: ErrImageTooLarge},
+		{name: "header at the limits", data: pngWithSize(t, 1000, 500), format: "png", width: 1000, height: 500, pages: 1},
+		{name: "header without pixels", data: pngWithSize(t, 0, 10), err: ErrCorruptImage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := Inspect(tt.data, limits)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("Inspect() error = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Inspect() error = %v", err)
+			}
+			want := InputInfo{Format: tt.format, Width: tt.width, Height: tt.height, Pages: tt.pages}
+			if *info != want {
+				t.Errorf("Inspect() = %+v, want %+v", *info, want)
+			}
+		})
+	}
+}
+
+func TestVerifyTruncatedPixels(t *testing.T) {
+	data := encodePNG(t, 300, 200)
+	// The header is intact, so only decoding the pixels finds the damage
+	truncated := data[:len(data)-30]
+	if _, err := Inspect(truncated, ImageLimits{}); err != nil {
+		t.Fatalf("Inspect() error = %v, want the header to pass", err)
+	}
+	if err := (imageSource{data: truncated}).verify(); !errors.Is(err, ErrCorruptImage) {
+		t.Errorf("verify() error = %v, want %v", err, ErrCorruptImage)
+	}
+	if err := (imageSource{data: data}).verify(); err != nil {
+		t.Errorf("verify() error = %v on an intact image", err)
+	}
+}
+
+func TestImageLimitsCheck(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits ImageLimits
+		width  int
+		height int
+		err    error
+	}{
+		{"no limits", ImageLimits{}, 100_000, 100_000, nil},
+		{"within", ImageLimits{MaxSide: 100, MaxPixels: 5000}, 100, 50, nil},
+		{"width over", ImageLimits{MaxSide: 100}, 101, 1, ErrImageTooLarge},
+		{"height over", ImageLimits{MaxSide: 100}, 1, 101, ErrImageTooLarge},
+		{"pixels over", ImageLimits{MaxPixels: 5000}, 100, 51, ErrImageTooLarge},
+		{"pixels over int32", ImageLimits{MaxPixels: 100_000_000}, 65535, 65535, ErrImageTooLarge},
+		{"zero width", ImageLimits{}, 0, 10, ErrCorruptImage},
+		{"negative height", ImageLimits{}, 10, -1, ErrCorruptImage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.limits.check(tt.width, tt.height)
+			if tt.err == nil && err != nil || tt.err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("check(%d, %d) = %v, want %v", tt.width, tt.height, err, tt.err)
+			}
+		})
+	}
+}
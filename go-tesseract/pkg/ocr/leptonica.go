@@ -0,0 +1,23 @@
+package ocr
+
+// #cgo CPPFLAGS: -I/usr/local/include
+// #cgo LDFLAGS: -L/usr/local/lib -lleptonica
+// #include <stdlib.h>
+// #include <leptonica/allheaders.h>
+import "C"
+
+import (
+	"strings"
+	"unsafe"
+)
+
+// leptonicaVersion asks the linked Leptonica for its version, e.g.
+// "1.84.1". gosseract links the same library with the same flags
+func leptonicaVersion() string {
+	v := C.getLeptonicaVersion()
+	if v == nil {
+		return ""
+	}
+	defer C.lept_free(unsafe.Pointer(v))
+	return strings.TrimPrefix(C.GoString(v), "leptonica-")
+}
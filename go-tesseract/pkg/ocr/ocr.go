@@ -1,39 +1,119 @@
 package ocr
 
 import (
+	"context"
 	"fmt"
+	"image"
 	"regexp"
 	"strings"
 
 	"github.com/otiai10/gosseract/v2"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-// Regex patterns pre-compiled for performance
-var (
-	// Clean non-Devanagari text, keeping numbers and punctuation
-	regexGibberish = regexp.MustCompile(`[^\x{0900}-\x{097F}0-9\s.,?!।\-()]+`)
-	// Ensure at least one Devanagari character exists in a line
-	regexHasDevanagari = regexp.MustCompile(`[\x{0900}-\x{097F}]`)
-	// Reduce multiple spaces
-	regexMultiSpace = regexp.MustCompile(`\s+`)
-)
+// Ensure at least one Devanagari character exists in a line
+var regexHasDevanagari = regexp.MustCompile(`[\x{0900}-\x{097F}]`)
 
 // ExtractFromImage performs OCR on an image file and returns structured results
 // This is the main exported function for library users
 func ExtractFromImage(imagePath string, config *OCRConfig) (*OCRResult, error) {
+	return ExtractFromImageContext(context.Background(), imagePath, config)
+}
+
+// ExtractFromImageContext is ExtractFromImage bounded by ctx.
+// When ctx ends first it returns a *TimeoutError (deadline) or an error
+// wrapping context.Canceled right away. Tesseract itself cannot be
+// interrupted: the page is still recognized in the background, and at most
+// runtime.NumCPU() such recognitions of the package-level functions run at
+// once, so further calls wait for a free slot
+func ExtractFromImageContext(ctx context.Context, imagePath string, config *OCRConfig) (*OCRResult, error) {
+	return extract(ctx, oneShot{}, imageSource{path: imagePath}, config)
+}
+
+// extract runs recognition on src with a client from clients and applies
+// the cleaning rules from config
+func extract(ctx context.Context, clients clientProvider, src imageSource, config *OCRConfig) (result *OCRResult, err error) {
 	if config == nil {
 		config = DefaultConfig()
 	}
 
-	// Extract lines from image
-	lines, avgConf, err := extractSentenceLevel(imagePath, config.Language)
+	ctx, span := startExtract(ctx, config)
+	defer func() {
+		if result != nil {
+			span.SetAttributes(
+				attribute.Int("ocr.line_count", result.LineCount),
+				attribute.Float64("ocr.average_confidence", result.AverageConfidence),
+			)
+		}
+		endSpan(span, err)
+	}()
+
+	original := src
+	if size := src.bounds(); !size.Empty() {
+		if err := config.ImageLimits.check(size.Dx(), size.Dy()); err != nil {
+			return nil, err
+		}
+	}
+
+	// Orientation detection and preprocessing are plain CPU work, so they
+	// run before a client is taken from the pool
+	var orientation *Orientation
+	var info *PreprocessInfo
+	if config.DetectOrientation || config.Preprocess.Enabled() {
+		end := startStage(ctx, config, StagePreprocess)
+		src, orientation, info, err = prepareSource(ctx, src, config)
+		end(err)
+		if err != nil {
+			return nil, fmt.Errorf("failed to preprocess image: %w", err)
+		}
+	}
+
+	key := configKey(config)
+	end := startStage(ctx, config, StageAcquire)
+	client, err := clients.acquire(ctx, key)
+	end(err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tesseract client: %w", err)
+	}
+	// Pixels are only decoded while a client is held, so at most one page
+	// per client is in memory
+	var pixels image.Image
+	rotated := orientation != nil && orientation.Applied
+	skewed := info != nil && info.SkewAngle != 0
+	switch {
+	case config.KeepPixels && !rotated && !skewed:
+		pixels, err = original.pixels()
+	case config.VerifyImage:
+		err = src.verify()
+	}
+	if err != nil {
+		clients.release(key, client, true)
+		return nil, err
+	}
+
+	// The client goes back to the provider when recognition really ends,
+	// which may be after ctx has already given up on it
+	end = startStage(ctx, config, StageRecognize)
+	rec, err := runRecognition(ctx, func() (*recognition, error) {
+		rec, err := recognize(client, src)
+		clients.release(key, client, err == nil)
+		return rec, err
+	})
+	end(err)
 	if err != nil {
 		return nil, fmt.Errorf("failed to extract text: %w", err)
 	}
+	if info != nil && info.Scale != 1 {
+		rec.rescale(1 / info.Scale)
+	}
+	lines, avgConf, page := rec.lines, rec.avgConf, rec.page
 
 	// Process and clean lines
+	end = startStage(ctx, config, StageClean)
+	filter := config.textFilter()
 	var cleanedLines []ExtractedLine
 	var validTexts []string
+	var corrections []Correction
 
 	for _, line := range lines {
 		// Skip low confidence lines if threshold is set
@@ -42,89 +122,86 @@ func ExtractFromImage(imagePath string, config *OCRConfig) (*OCRResult, error) {
 		}
 
 		cleaned := line.Text
-		if config.CleanDevanagari {
-			cleaned = cleanDevanagariText(line.Text)
+		if filter != nil {
+			cleaned = filter.Filter(cleaned)
 		}
 
 		// Skip empty or invalid lines
-		if cleaned == "" || !regexHasDevanagari.MatchString(cleaned) {
+		if cleaned == "" || !config.keepText(cleaned) {
 			continue
 		}
 
+		if config.Spell.Lexicon != nil {
+			var fixes []Correction
+			cleaned, fixes = config.Spell.correctText(cleaned)
+			for _, c := range fixes {
+				c.Line = len(cleanedLines)
+				corrections = append(corrections, c)
+			}
+		}
+
 		cleanedLines = append(cleanedLines, ExtractedLine{
 			Text:       cleaned,
 			Confidence: line.Confidence,
+			Box:        line.Box,
+			Script:     DetectScript(cleaned),
+			Spans:      ScriptSpans(cleaned),
 		})
 		validTexts = append(validTexts, cleaned)
 	}
 
-	result := &OCRResult{
+	result = &OCRResult{
 		Text:              strings.Join(validTexts, "\n\n"),
 		AverageConfidence: avgConf,
 		LineCount:         len(cleanedLines),
+		Corrections:       corrections,
+		Orientation:       orientation,
+		Preprocessing:     info,
+		Pixels:            pixels,
+		recognized:        len(lines),
 	}
 
 	if config.IncludeLines {
 		result.Lines = cleanedLines
 	}
 
-	return result, nil
-}
-
-// extractSentenceLevel performs OCR at the line/sentence level
-// Returns: Slice of lines, Average Confidence, Error
-func extractSentenceLevel(imagePath string, language string) ([]ExtractedLine, float64, error) {
-	client := gosseract.NewClient()
-	defer client.Close()
-
-	if err := client.SetImage(imagePath); err != nil {
-		return nil, 0, fmt.Errorf("failed to set image: %w", err)
-	}
-
-	if err := client.SetLanguage(language); err != nil {
-		return nil, 0, fmt.Errorf("failed to set language: %w", err)
+	if config.Granularity != GranularityNone {
+		result.Page = filterPage(page, config)
+		truncatePage(result.Page, config.Granularity)
 	}
+	end(nil)
 
-	// Get Text Lines (Sentence Level)
-	boundingBoxes, err := client.GetBoundingBoxes(gosseract.RIL_TEXTLINE)
-	if err != nil {
-		return nil, 0, fmt.Errorf("failed to get bounding boxes: %w", err)
-	}
-
-	var results []ExtractedLine
-	var totalConfidence float64
-	var lineCount int
-
-	for _, box := range boundingBoxes {
-		cleanText := strings.TrimSpace(box.Word)
-		if cleanText != "" {
-			results = append(results, ExtractedLine{
-				Text:       cleanText,
-				Confidence: box.Confidence,
-			})
-			totalConfidence += box.Confidence
-			lineCount++
+	if config.KeepImage {
+		result.Image, err = pageImage(original, orientation, info)
+		if err != nil {
+			return nil, fmt.Errorf("failed to keep page image: %w", err)
 		}
 	}
 
-	avgConfidence := 0.0
-	if lineCount > 0 {
-		avgConfidence = totalConfidence / float64(lineCount)
-	}
-
-	return results, avgConfidence, nil
+	return result, nil
 }
 
-// cleanDevanagariText removes non-Devanagari gibberish and normalizes spacing
-func cleanDevanagariText(text string) string {
-	// 1. Remove non-Nepali gibberish (English noise, random symbols)
-	cleaned := regexGibberish.ReplaceAllString(text, " ")
-
-	// 2. Fix multiple spaces
-	cleaned = regexMultiSpace.ReplaceAllString(cleaned, " ")
+// recognize extracts the page tree and its lines from the image in one
+// Tesseract pass. Lines always come from the tree, so their confidence,
+// and the average over them, do not depend on the granularity asked for
+func recognize(client *gosseract.Client, src imageSource) (*recognition, error) {
+	if err := src.setOn(client); err != nil {
+		return nil, fmt.Errorf("failed to set image: %w", err)
+	}
 
-	// 3. Trim whitespace
-	cleaned = strings.TrimSpace(cleaned)
+	page, err := extractPageLevel(client, src.bounds())
+	if err != nil {
+		return nil, err
+	}
+	lines := pageLines(page)
+	return &recognition{lines: lines, avgConf: averageLineConfidence(lines), page: page}, nil
+}
 
-	return cleaned
+// averageLineConfidence returns the mean confidence over all lines
+func averageLineConfidence(lines []ExtractedLine) float64 {
+	var total float64
+	for _, line := range lines {
+		total += line.Confidence
+	}
+	return mean(total, len(lines))
 }
@@ -0,0 +1,193 @@
+package ocr
+
+import (
+	"image"
+	"math"
+)
+
+// DefaultOrientationConfidence is the confidence (0-100) a detected
+// orientation needs before the page is rotated
+const DefaultOrientationConfidence = 50.0
+
+// orientationMaxSide is the longest side pages are shrunk to before
+// orientation detection; profiles need structure, not detail
+const orientationMaxSide = 1200
+
+// Orientation reports how the page was rotated. Angle is how far the page
+// must be turned clockwise to read upright: 0, 90, 180 or 270. When Applied
+// is true the page was turned before recognition and all boxes refer to the
+// upright page
+type Orientation struct {
+	Angle      int     `json:"angle"`
+	Confidence float64 `json:"confidence"`
+	Applied    bool    `json:"applied"`
+}
+
+// detectOrientation guesses the orientation of a page of Devanagari text.
+// Gosseract exposes no OSD, so this uses two projection heuristics:
+//
+//   - Text lines make the ink profile across them spiky and the profile
+//     along them flat, which separates 0/180 from 90/270.
+//   - Every Devanagari line hangs from its headline (shirorekha), so the
+//     densest row of a line sits near its top on an upright page and near
+//     its bottom on an upside-down one.
+//
+// The confidence is the weaker of the two signals, scaled to 0-100
+func detectOrientation(g *image.Gray) Orientation {
+	if side := max(g.Bounds().Dx(), g.Bounds().Dy()); side > orientationMaxSide {
+		g = scaleGray(g, float64(orientationMaxSide)/float64(side))
+	}
+	t := otsuThreshold(g)
+
+	rows := profileScore(inkProfile(g, t, true))
+	cols := profileScore(inkProfile(g, t, false))
+
+	angle := 0
+	hi, lo := rows, cols
+	if cols > rows {
+		// Lines run vertically; turn them level before looking for headlines
+		angle = 90
+		hi, lo = cols, rows
+		g = rotateOrthogonal(g, 90)
+	}
+	axisConf := 1.0
+	if lo > 0 {
+		axisConf = math.Min(1, hi/lo-1)
+	}
+	if hi == 0 {
+		axisConf = 0
+	}
+
+	up, down := headlineVotes(g, t)
+	var flipConf float64
+	if up+down > 0 {
+		flipConf = math.Abs(up-down) / (up + down)
+	}
+	if down > up {
+		angle = (angle + 180) % 360
+	}
+
+	return Orientation{
+		Angle:      angle,
+		Confidence: math.Round(100*math.Min(axisConf, flipConf)*10) / 10,
+	}
+}
+
+// inkProfile counts ink pixels per row (rows true) or per column
+func inkProfile(g *image.Gray, t uint8, rows bool) []int {
+	w, h := g.Bounds().Dx(), g.Bounds().Dy()
+	n := w
+	if rows {
+		n = h
+	}
+	profile := make([]int, n)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if g.Pix[y*g.Stride+x] > t {
+				continue
+			}
+			if rows {
+				profile[y]++
+			} else {
+				profile[x]++
+			}
+		}
+	}
+	return profile
+}
+
+// profileScore is the mean squared difference between neighbouring bins,
+// high when the profile alternates between lines and gaps
+func profileScore(profile []int) float64 {
+	if len(profile) < 2 {
+		return 0
+	}
+	var s float64
+	for i := 1; i < len(profile); i++ {
+		d := float64(profile[i] - profile[i-1])
+		s += d * d
+	}
+	return s / float64(len(profile)-1)
+}
+
+// headlineVotes splits the page into text lines using the row profile and
+// lets every line with a clear headline vote, weighted by the headline's
+// ink, for the headline being in its upper or lower part
+func headlineVotes(g *image.Gray, t uint8) (up, down float64) {
+	profile := inkProfile(g, t, true)
+
+	peak := 0
+	for _, n := range profile {
+		peak = max(peak, n)
+	}
+	// Rows with less ink than this are gaps between lines
+	gap := max(peak/50, 1)
+
+	vote := func(start, end int) {
+		height := end - start
+		if height < 6 {
+			return
+		}
+		top, total := start, 0
+		for y := start; y < end; y++ {
+			total += profile[y]
+			if profile[y] > profile[top] {
+				top = y
+			}
+		}
+		// A headline row holds far more ink than an average row of its line
+		if float64(profile[top]) < 1.8*float64(total)/float64(height) {
+			return
+		}
+		switch pos := float64(top-start) / float64(height-1); {
+		case pos < 0.4:
+			up += float64(profile[top])
+		case pos > 0.6:
+			down += float64(profile[top])
+		}
+	}
+
+	start := -1
+	for y, n := range profile {
+		switch {
+		case n >= gap && start < 0:
+			start = y
+		case n < gap && start >= 0:
+			vote(start, y)
+			start = -1
+		}
+	}
+	if start >= 0 {
+		vote(start, len(profile))
+	}
+	return up, down
+}
+
+// rotateOrthogonal turns the image clockwise by 90, 180 or 270 degrees
+func rotateOrthogonal(g *image.Gray, angle int) *image.Gray {
+	w, h := g.Bounds().Dx(), g.Bounds().Dy()
+	var out *image.Gray
+	switch angle {
+	case 90, 270:
+		out = image.NewGray(image.Rect(0, 0, h, w))
+	case 180:
+		out = image.NewGray(image.Rect(0, 0, w, h))
+	default:
+		return g
+	}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := g.Pix[y*g.Stride+x]
+			switch angle {
+			case 90:
+				out.Pix[x*out.Stride+h-1-y] = v
+			case 180:
+				out.Pix[(h-1-y)*out.Stride+w-1-x] = v
+			case 270:
+				out.Pix[(w-1-x)*out.Stride+y] = v
+			}
+		}
+	}
+	return out
+}
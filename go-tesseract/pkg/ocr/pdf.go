@@ -0,0 +1,234 @@
+package ocr
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DefaultPDFDPI is the resolution PDF pages are rasterized at when OCRConfig.PDFDPI is zero
+const DefaultPDFDPI = 300
+
+// MaxPDFDPI is the highest resolution PDF pages are rasterized at; an A4
+// page at 1200 DPI is already about 140 megapixels
+const MaxPDFDPI = 1200
+
+// ErrTooManyPages is returned for PDFs and TIFFs with more pages than
+// OCRConfig.MaxPages
+var ErrTooManyPages = errors.New("too many pages")
+
+// Rasterizer renders the pages of a PDF into encoded images. Pages are
+// asked for one at a time, so only one page image needs to be held
+type Rasterizer interface {
+	// PageCount returns the number of pages in pdf
+	PageCount(ctx context.Context, pdf []byte) (int, error)
+	// RasterizePage renders page (counted from 1) of pdf at dpi
+	RasterizePage(ctx context.Context, pdf []byte, page, dpi int) ([]byte, error)
+}
+
+// PageSize is the size of a PDF page in points (1/72 inch)
+type PageSize struct {
+	Width, Height float64
+}
+
+// PageSizer is implemented by Rasterizers that can read page sizes without
+// rendering, so pages over OCRConfig.ImageLimits are refused before any
+// bitmap is made. Other Rasterizers have their pages checked once rendered
+type PageSizer interface {
+	// PageSizes returns the size of every one of the first count pages of pdf
+	PageSizes(ctx context.Context, pdf []byte, count int) ([]PageSize, error)
+}
+
+// PdftoppmRasterizer renders pages with poppler's pdftoppm and counts and
+// measures them with pdfinfo, both of which work fully offline. Path and
+// InfoPath default to "pdftoppm" and "pdfinfo" from $PATH
+type PdftoppmRasterizer struct {
+	Path     string
+	InfoPath string
+}
+
+var (
+	pdfinfoPages = regexp.MustCompile(`(?m)^Pages:\s+(\d+)`)
+	// pdfinfo only numbers the lines when asked about more than one page
+	pdfinfoMediaBoxes = regexp.MustCompile(`(?m)^(?:Page\s+(\d+)\s+)?MediaBox:\s+(\S+)\s+(\S+)\s+(\S+)\s+(\S+)`)
+)
+
+// pdfinfo runs pdfinfo with args on pdf
+func (r PdftoppmRasterizer) pdfinfo(ctx context.Context, pdf []byte, args ...string) ([]byte, error) {
+	bin := r.InfoPath
+	if bin == "" {
+		bin = "pdfinfo"
+	}
+	return runPoppler(ctx, bin, pdf, append(args, "-")...)
+}
+
+// PageCount reads the page count from pdfinfo
+func (r PdftoppmRasterizer) PageCount(ctx context.Context, pdf []byte) (int, error) {
+	out, err := r.pdfinfo(ctx, pdf)
+	if err != nil {
+		return 0, err
+	}
+	m := pdfinfoPages.FindSubmatch(out)
+	if m == nil {
+		return 0, fmt.Errorf("pdfinfo printed no page count")
+	}
+	return strconv.Atoi(string(m[1]))
+}
+
+// PageSizes reads the media box of every page from pdfinfo -box. pdftoppm
+// renders the media box unless told otherwise, and the crop box lies within it
+func (r PdftoppmRasterizer) PageSizes(ctx context.Context, pdf []byte, count int) ([]PageSize, error) {
+	out, err := r.pdfinfo(ctx, pdf, "-box", "-f", "1", "-l", strconv.Itoa(count))
+	if err != nil {
+		return nil, err
+	}
+
+	sizes := make([]PageSize, count)
+	found := 0
+	for _, m := range pdfinfoMediaBoxes.FindAllSubmatch(out, -1) {
+		page := 1
+		if len(m[1]) > 0 {
+			page, err = strconv.Atoi(string(m[1]))
+			if err != nil || page < 1 || page > count {
+				continue
+			}
+		}
+		var box [4]float64
+		for i := range box {
+			if box[i], err = strconv.ParseFloat(string(m[i+2]), 64); err != nil {
+				return nil, fmt.Errorf("pdfinfo printed a bad media box for page %d", page)
+			}
+		}
+		sizes[page-1] = PageSize{Width: math.Abs(box[2] - box[0]), Height: math.Abs(box[3] - box[1])}
+		found++
+	}
+	if found != count {
+		return nil, fmt.Errorf("pdfinfo printed %d of %d page sizes", found, count)
+	}
+	return sizes, nil
+}
+
+// RasterizePage renders a single page to PNG on stdout
+func (r PdftoppmRasterizer) RasterizePage(ctx context.Context, pdf []byte, page, dpi int) ([]byte, error) {
+	bin := r.Path
+	if bin == "" {
+		bin = "pdftoppm"
+	}
+	n := strconv.Itoa(page)
+	// Without an output root, -singlefile writes the page to stdout
+	return runPoppler(ctx, bin, pdf, "-r", strconv.Itoa(dpi), "-png", "-f", n, "-l", n, "-singlefile", "-")
+}
+
+// runPoppler runs a poppler tool that reads the PDF from stdin ("-") and
+// returns what it printed
+func runPoppler(ctx context.Context, bin string, pdf []byte, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, bin, args...)
+	cmd.Stdin = bytes.NewReader(pdf)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, contextError(ctx.Err(), 0)
+		}
+		return nil, fmt.Errorf("%s failed: %w: %s", filepath.Base(bin), err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// ExtractFromPDF rasterizes every page of a PDF file and performs OCR on each
+func ExtractFromPDF(pdfPath string, config *OCRConfig) (*DocumentResult, error) {
+	return ExtractFromPDFContext(context.Background(), pdfPath, config)
+}
+
+// ExtractFromPDFContext is ExtractFromPDF bounded by ctx
+func ExtractFromPDFContext(ctx context.Context, pdfPath string, config *OCRConfig) (*DocumentResult, error) {
+	data, err := os.ReadFile(pdfPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pdf: %w", err)
+	}
+	return extractPDF(ctx, oneShot{}, data, config)
+}
+
+// ExtractFromPDF rasterizes every page of a PDF file and performs OCR on each using pooled clients
+func (e *Engine) ExtractFromPDF(ctx context.Context, pdfPath string, config *OCRConfig) (*DocumentResult, error) {
+	data, err := os.ReadFile(pdfPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pdf: %w", err)
+	}
+	return extractPDF(ctx, e, data, config)
+}
+
+// ExtractFromPDFBytes is ExtractFromPDF for a PDF held in memory
+func (e *Engine) ExtractFromPDFBytes(ctx context.Context, data []byte, config *OCRConfig) (*DocumentResult, error) {
+	return extractPDF(ctx, e, data, config)
+}
+
+func extractPDF(ctx context.Context, clients clientProvider, data []byte, config *OCRConfig) (*DocumentResult, error) {
+	if config == nil {
+		config = DefaultConfig()
+	}
+
+	dpi := config.PDFDPI
+	if dpi <= 0 {
+		dpi = DefaultPDFDPI
+	}
+	if dpi > MaxPDFDPI {
+		return nil, fmt.Errorf("pdf dpi %d is over %d", dpi, MaxPDFDPI)
+	}
+	rasterizer := config.Rasterizer
+	if rasterizer == nil {
+		rasterizer = PdftoppmRasterizer{}
+	}
+
+	count, err := rasterizer.PageCount(ctx, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pdf: %w", err)
+	}
+	if count == 0 {
+		return nil, fmt.Errorf("pdf has no pages")
+	}
+	if config.MaxPages > 0 && count > config.MaxPages {
+		return nil, fmt.Errorf("%w: the PDF has %d pages, a PDF or TIFF may have at most %d", ErrTooManyPages, count, config.MaxPages)
+	}
+	if err := checkPageSizes(ctx, rasterizer, data, count, dpi, config.ImageLimits); err != nil {
+		return nil, err
+	}
+
+	return extractPages(ctx, clients, count, func(i int) (imageSource, error) {
+		image, err := rasterizer.RasterizePage(ctx, data, i+1, dpi)
+		if err != nil {
+			return imageSource{}, fmt.Errorf("failed to rasterize: %w", err)
+		}
+		return imageSource{data: image}, nil
+	}, config)
+}
+
+// checkPageSizes refuses a PDF with a page that would render over limits at
+// dpi, before any page is rendered, when rasterizer can measure its pages
+func checkPageSizes(ctx context.Context, rasterizer Rasterizer, data []byte, count, dpi int, limits ImageLimits) error {
+	sizer, ok := rasterizer.(PageSizer)
+	if !ok || limits == (ImageLimits{}) {
+		return nil
+	}
+	sizes, err := sizer.PageSizes(ctx, data, count)
+	if err != nil {
+		return fmt.Errorf("failed to read pdf: %w", err)
+	}
+	for i, size := range sizes {
+		width := int(math.Ceil(size.Width / 72 * float64(dpi)))
+		height := int(math.Ceil(size.Height / 72 * float64(dpi)))
+		if err := limits.check(width, height); err != nil {
+			return fmt.Errorf("page %d at %d dpi: %w", i+1, dpi, err)
+		}
+	}
+	return nil
+}
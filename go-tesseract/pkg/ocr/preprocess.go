@@ -0,0 +1,300 @@
+package ocr
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Binarization selects how the grayscale page is turned into black and white
+type Binarization string
+
+const (
+	BinarizeNone    Binarization = ""
+	BinarizeOtsu    Binarization = "otsu"
+	BinarizeSauvola Binarization = "sauvola"
+)
+
+// Preprocessing defaults
+const (
+	defaultMaxSkew         = 5.0
+	defaultSauvolaWindow   = 25
+	defaultSauvolaK        = 0.34
+	defaultPageWidthInches = 8.27 // A4, the size of Rajpatra and most office scans
+	maxUpscale             = 4.0
+)
+
+// PreprocessConfig selects image clean-up steps run before recognition.
+// Enabled steps always run in the same order: grayscale, border removal,
+// denoise, deskew, upscale, binarize. Any step implies grayscale
+type PreprocessConfig struct {
+	Grayscale    bool
+	RemoveBorder bool
+	// Denoise applies a 3x3 median filter
+	Denoise bool
+	// Deskew straightens text rotated by up to MaxSkew degrees (5 when zero)
+	Deskew  bool
+	MaxSkew float64
+	// TargetDPI upscales the page towards this resolution; pages are never
+	// downscaled, nor scaled past OCRConfig.ImageLimits. The current
+	// resolution is SourceDPI, or estimated from the pixel width assuming
+	// an A4 page when SourceDPI is zero
+	TargetDPI int
+	SourceDPI int
+	Binarize  Binarization
+	// SauvolaWindow (pixels, 25 when zero) and SauvolaK (0.34 when zero) tune Sauvola binarization
+	SauvolaWindow int
+	SauvolaK      float64
+	// DebugDir, when set, receives a PNG of the image after every step
+	DebugDir string
+}
+
+// Enabled reports whether any preprocessing step is selected
+func (p PreprocessConfig) Enabled() bool {
+	return p.Grayscale || p.RemoveBorder || p.Denoise || p.Deskew || p.TargetDPI > 0 || p.Binarize != BinarizeNone
+}
+
+// ParsePreprocess builds a PreprocessConfig from a comma separated list of
+// steps as accepted by the CLI and API, e.g. "border,denoise,deskew,dpi=300,sauvola".
+// Known steps: grayscale, border, denoise, deskew, dpi=N, otsu, sauvola
+func ParsePreprocess(spec string) (PreprocessConfig, error) {
+	var p PreprocessConfig
+	for _, step := range strings.Split(spec, ",") {
+		step = strings.ToLower(strings.TrimSpace(step))
+		switch {
+		case step == "":
+		case step == "grayscale":
+			p.Grayscale = true
+		case step == "border":
+			p.RemoveBorder = true
+		case step == "denoise":
+			p.Denoise = true
+		case step == "deskew":
+			p.Deskew = true
+		case step == "otsu":
+			p.Binarize = BinarizeOtsu
+		case step == "sauvola":
+			p.Binarize = BinarizeSauvola
+		case strings.HasPrefix(step, "dpi="):
+			dpi, err := strconv.Atoi(strings.TrimPrefix(step, "dpi="))
+			if err != nil || dpi <= 0 {
+				return p, fmt.Errorf("invalid preprocessing step %q: dpi must be a positive integer", step)
+			}
+			if dpi > MaxPDFDPI {
+				return p, fmt.Errorf("invalid preprocessing step %q: dpi may be at most %d", step, MaxPDFDPI)
+			}
+			p.TargetDPI = dpi
+		default:
+			return p, fmt.Errorf("unknown preprocessing step %q (use grayscale, border, denoise, deskew, dpi=N, otsu or sauvola)", step)
+		}
+	}
+	return p, nil
+}
+
+// PreprocessInfo reports what preprocessing did to the page. Boxes in the
+// result are mapped back through Scale, so they refer to the original image;
+// after deskewing they refer to the straightened page
+type PreprocessInfo struct {
+	Steps     []string `json:"steps"`
+	SkewAngle float64  `json:"skew_angle"`
+	Scale     float64  `json:"scale"`
+}
+
+// prepareSource decodes src once for orientation detection and
+// preprocessing and returns the page Tesseract should see. src comes back
+// as is when neither changed the image
+func prepareSource(ctx context.Context, src imageSource, config *OCRConfig) (imageSource, *Orientation, *PreprocessInfo, error) {
+	img, err := src.decode()
+	if err != nil {
+		return src, nil, nil, fmt.Errorf("%w: %v", ErrCorruptImage, err)
+	}
+	gray := toGray(img)
+
+	var orientation *Orientation
+	if config.DetectOrientation {
+		o := detectOrientation(gray)
+		threshold := config.OrientationConfidence
+		if threshold <= 0 {
+			threshold = DefaultOrientationConfidence
+		}
+		if o.Angle != 0 && o.Confidence >= threshold {
+			gray = rotateOrthogonal(gray, o.Angle)
+			o.Applied = true
+		}
+		orientation = &o
+	}
+
+	var info *PreprocessInfo
+	if config.Preprocess.Enabled() {
+		gray, info, err = preprocessImage(ctx, gray, config.Preprocess, config.ImageLimits)
+		if err != nil {
+			return src, nil, nil, err
+		}
+	} else if orientation == nil || !orientation.Applied {
+		return src, orientation, nil, nil
+	}
+
+	out, err := decodedSource(gray)
+	return out, orientation, info, err
+}
+
+// preprocessImage runs the enabled steps on gray, checking ctx between steps.
+// Upscaling stops short of limits
+func preprocessImage(ctx context.Context, gray *image.Gray, cfg PreprocessConfig, limits ImageLimits) (*image.Gray, *PreprocessInfo, error) {
+	start := time.Now()
+	info := &PreprocessInfo{Scale: 1}
+
+	if cfg.DebugDir != "" {
+		if err := os.MkdirAll(cfg.DebugDir, 0755); err != nil {
+			return nil, nil, fmt.Errorf("failed to create debug directory: %w", err)
+		}
+	}
+
+	step := func(name string, fn func(*image.Gray) *image.Gray) error {
+		if err := ctx.Err(); err != nil {
+			return contextError(err, time.Since(start))
+		}
+		gray = fn(gray)
+		info.Steps = append(info.Steps, name)
+		if cfg.DebugDir == "" {
+			return nil
+		}
+		path := filepath.Join(cfg.DebugDir, fmt.Sprintf("%02d-%s.png", len(info.Steps), name))
+		return writePNG(path, gray)
+	}
+
+	if err := step("grayscale", func(g *image.Gray) *image.Gray { return g }); err != nil {
+		return nil, nil, err
+	}
+
+	if cfg.RemoveBorder {
+		if err := step("border", removeBorder); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if cfg.Denoise {
+		if err := step("denoise", medianFilter); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if cfg.Deskew {
+		maxSkew := cfg.MaxSkew
+		if maxSkew <= 0 {
+			maxSkew = defaultMaxSkew
+		}
+		err := step("deskew", func(g *image.Gray) *image.Gray {
+			info.SkewAngle = detectSkew(g, maxSkew)
+			if info.SkewAngle == 0 {
+				return g
+			}
+			return rotateGray(g, info.SkewAngle)
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if cfg.TargetDPI > 0 {
+		sourceDPI := float64(cfg.SourceDPI)
+		if sourceDPI <= 0 {
+			sourceDPI = float64(gray.Bounds().Dx()) / defaultPageWidthInches
+		}
+		factor := math.Min(float64(cfg.TargetDPI)/sourceDPI, maxUpscale)
+		factor = limits.clampScale(gray.Bounds().Dx(), gray.Bounds().Dy(), factor)
+		if factor > 1.05 {
+			info.Scale = factor
+			if err := step("upscale", func(g *image.Gray) *image.Gray { return scaleGray(g, factor) }); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	switch cfg.Binarize {
+	case BinarizeOtsu:
+		if err := step("otsu", binarizeOtsu); err != nil {
+			return nil, nil, err
+		}
+	case BinarizeSauvola:
+		window, k := cfg.SauvolaWindow, cfg.SauvolaK
+		if window <= 0 {
+			window = defaultSauvolaWindow
+		}
+		if k <= 0 {
+			k = defaultSauvolaK
+		}
+		err := step("sauvola", func(g *image.Gray) *image.Gray { return binarizeSauvola(g, window, k) })
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return gray, info, nil
+}
+
+// clampScale lowers factor until a width x height image scaled by it, as
+// scaleGray rounds it, stays within the limits
+func (l ImageLimits) clampScale(width, height int, factor float64) float64 {
+	if l.MaxSide > 0 {
+		factor = math.Min(factor, float64(l.MaxSide)/float64(max(width, height)))
+	}
+	if l.MaxPixels > 0 {
+		factor = math.Min(factor, math.Sqrt(float64(l.MaxPixels)/(float64(width)*float64(height))))
+	}
+	// Rounding may still add a pixel
+	for factor > 1 && l.check(int(math.Round(float64(width)*factor)), int(math.Round(float64(height)*factor))) != nil {
+		factor -= 1 / float64(max(width, height))
+	}
+	return factor
+}
+
+// rescale maps every box by factor, e.g. back from an upscaled page to the original
+func (rec *recognition) rescale(factor float64) {
+	scaleBox := func(b *BoundingBox) {
+		b.X = int(math.Round(float64(b.X) * factor))
+		b.Y = int(math.Round(float64(b.Y) * factor))
+		b.Width = int(math.Round(float64(b.Width) * factor))
+		b.Height = int(math.Round(float64(b.Height) * factor))
+	}
+
+	for i := range rec.lines {
+		scaleBox(&rec.lines[i].Box)
+	}
+	if rec.page == nil {
+		return
+	}
+
+	scaleBox(&rec.page.Box)
+	for bi := range rec.page.Blocks {
+		block := &rec.page.Blocks[bi]
+		scaleBox(&block.Box)
+		for pi := range block.Paragraphs {
+			para := &block.Paragraphs[pi]
+			scaleBox(&para.Box)
+			for li := range para.Lines {
+				line := &para.Lines[li]
+				scaleBox(&line.Box)
+				for wi := range line.Words {
+					scaleBox(&line.Words[wi].Box)
+				}
+			}
+		}
+	}
+}
+
+func writePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return png.Encode(f, img)
+}
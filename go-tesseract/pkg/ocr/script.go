@@ -0,0 +1,174 @@
+package ocr
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/ToniBirat7/tesseract_ocr_ne/pkg/textfilter"
+)
+
+// Script is the writing system detected for a line, word or span
+type Script string
+
+const (
+	ScriptNone       Script = ""
+	ScriptDevanagari Script = "devanagari"
+	ScriptLatin      Script = "latin"
+	ScriptDigits     Script = "digits"
+	ScriptMixed      Script = "mixed"
+	ScriptOther      Script = "other"
+)
+
+// languageScripts maps Tesseract language codes to the script they are written in
+var languageScripts = map[string]Script{
+	"nep": ScriptDevanagari,
+	"hin": ScriptDevanagari,
+	"mar": ScriptDevanagari,
+	"san": ScriptDevanagari,
+	"eng": ScriptLatin,
+	"fra": ScriptLatin,
+	"deu": ScriptLatin,
+	"spa": ScriptLatin,
+}
+
+// mixedPunctuation is kept on top of textfilter.DefaultPunctuation when
+// Latin text is expected, for case numbers such as "Writ No. 078/79"
+const mixedPunctuation = "/:;%&'\""
+
+// Span is a run of words in the same script
+type Span struct {
+	Text   string `json:"text"`
+	Script Script `json:"script"`
+}
+
+// DetectScript classifies text by the letters it contains. Text with
+// letters from both Devanagari and Latin is ScriptMixed, text with digits
+// (ASCII or Devanagari) but no letters is ScriptDigits, and text with
+// neither is ScriptNone
+func DetectScript(text string) Script {
+	var devanagari, latin, other, digits bool
+	for _, r := range text {
+		switch {
+		case unicode.IsDigit(r):
+			digits = true
+		case unicode.In(r, textfilter.Devanagari) && (unicode.IsLetter(r) || unicode.Is(unicode.M, r)):
+			devanagari = true
+		case unicode.In(r, textfilter.Latin):
+			latin = true
+		case unicode.IsLetter(r):
+			other = true
+		}
+	}
+
+	switch {
+	case devanagari && latin:
+		return ScriptMixed
+	case devanagari:
+		return ScriptDevanagari
+	case latin:
+		return ScriptLatin
+	case other:
+		return ScriptOther
+	case digits:
+		return ScriptDigits
+	}
+	return ScriptNone
+}
+
+// ScriptSpans splits text into runs of words in the same script.
+// Punctuation-only words join the run before them
+func ScriptSpans(text string) []Span {
+	var spans []Span
+	for _, word := range strings.Fields(text) {
+		script := DetectScript(word)
+		n := len(spans)
+		switch {
+		case n > 0 && (script == spans[n-1].Script || script == ScriptNone):
+			spans[n-1].Text += " " + word
+		case n > 0 && spans[n-1].Script == ScriptNone:
+			spans[n-1].Text += " " + word
+			spans[n-1].Script = script
+		default:
+			spans = append(spans, Span{Text: word, Script: script})
+		}
+	}
+	return spans
+}
+
+// languages returns the Tesseract languages to load, Languages when set and Language otherwise
+func (c *OCRConfig) languages() []string {
+	if len(c.Languages) > 0 {
+		return c.Languages
+	}
+	return []string{c.Language}
+}
+
+// scripts returns the distinct scripts of the configured languages.
+// Languages with an unknown script add ScriptOther
+func (c *OCRConfig) scripts() []Script {
+	var scripts []Script
+	seen := map[Script]bool{}
+	for _, lang := range c.languages() {
+		script, ok := languageScripts[lang]
+		if !ok {
+			script = ScriptOther
+		}
+		if !seen[script] {
+			seen[script] = true
+			scripts = append(scripts, script)
+		}
+	}
+	return scripts
+}
+
+// mixedScript reports whether more than one language is recognized
+func (c *OCRConfig) mixedScript() bool {
+	return len(c.languages()) > 1
+}
+
+// cleanup is the filter CleanDevanagari selects: the classic Devanagari
+// cleanup for Devanagari languages, otherwise one that also keeps the
+// letters of the other configured scripts
+func (c *OCRConfig) cleanup() textfilter.Chain {
+	scripts := c.scripts()
+	if len(scripts) == 1 && scripts[0] == ScriptDevanagari {
+		return textfilter.DevanagariCleanup()
+	}
+
+	tables := []*unicode.RangeTable{textfilter.ASCIIDigits}
+	for _, s := range scripts {
+		switch s {
+		case ScriptDevanagari:
+			tables = append(tables, textfilter.Devanagari)
+		case ScriptLatin:
+			tables = append(tables, textfilter.Latin)
+		case ScriptOther:
+			// Unknown script: only normalize whitespace
+			return textfilter.Chain{textfilter.Whitespace{}}
+		}
+	}
+	return textfilter.Chain{
+		textfilter.ScriptFilter{Scripts: tables, Keep: textfilter.DefaultPunctuation + mixedPunctuation},
+		textfilter.Whitespace{},
+	}
+}
+
+// keepText reports whether a cleaned line is worth returning. With one
+// language it must contain a letter of that language's script; with
+// several, any letter or number (e.g. a case number) is kept
+func (c *OCRConfig) keepText(text string) bool {
+	script := DetectScript(text)
+	if c.mixedScript() {
+		return script != ScriptNone
+	}
+
+	switch want := c.scripts()[0]; want {
+	case ScriptDevanagari:
+		// Any Devanagari character, so lines of Devanagari digits stay too
+		return regexHasDevanagari.MatchString(text)
+	case ScriptOther:
+		return script != ScriptNone && script != ScriptDigits
+	default:
+		return script == want || script == ScriptMixed
+	}
+}
@@ -0,0 +1,222 @@
+package ocr
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// DefaultPDFFont is where Alpine's font-noto-devanagari package installs
+// Noto Sans Devanagari, used by the Docker image
+const DefaultPDFFont = "/usr/share/fonts/noto/NotoSansDevanagari-Regular.ttf"
+
+// pdfFontFamily is the name the text layer font is registered under
+const pdfFontFamily = "ocrtext"
+
+// PDFOptions configures WriteSearchablePDF
+type PDFOptions struct {
+	// FontPath is a TrueType font covering Devanagari (and Latin for mixed
+	// pages), embedded for the text layer. DefaultPDFFont when empty
+	FontPath string
+	// DPI is the resolution of the page images and sets the page size
+	// (DefaultPDFDPI when zero). Use OCRConfig.PDFDPI for rasterized PDFs
+	DPI int
+}
+
+// PDFPage is one page of a searchable PDF: the scanned image and the page
+// tree whose words become the text layer
+type PDFPage struct {
+	// Image is the encoded page image (PNG and JPEG are embedded as is)
+	Image []byte
+	Page  *Page
+}
+
+// PDFPages returns the page of a result extracted with KeepImage and
+// Granularity word, ready for WriteSearchablePDF
+func (r *OCRResult) PDFPages() []PDFPage {
+	return []PDFPage{{Image: r.Image, Page: r.Page}}
+}
+
+// PDFPages returns every page of a document extracted with KeepImage and
+// Granularity word, in order
+func (d *DocumentResult) PDFPages() []PDFPage {
+	pages := make([]PDFPage, 0, len(d.Pages))
+	for _, p := range d.Pages {
+		pages = append(pages, p.PDFPages()...)
+	}
+	return pages
+}
+
+// WriteSearchablePDF writes a PDF that looks like the scanned pages but has
+// every recognized word as invisible text over its box, so the document can
+// be searched and text selected. Pages that were turned upright or
+// deskewed are embedded as recognized, so the text stays over its words
+func WriteSearchablePDF(w io.Writer, pages []PDFPage, opts PDFOptions) error {
+	if len(pages) == 0 {
+		return fmt.Errorf("no pages to write")
+	}
+	if opts.FontPath == "" {
+		opts.FontPath = DefaultPDFFont
+	}
+	if opts.DPI <= 0 {
+		opts.DPI = DefaultPDFDPI
+	}
+	font, err := os.ReadFile(opts.FontPath)
+	if err != nil {
+		return fmt.Errorf("failed to read pdf font: %w", err)
+	}
+
+	// Points per image pixel
+	scale := 72 / float64(opts.DPI)
+
+	pdf := gofpdf.New("P", "pt", "A4", "")
+	pdf.SetCreator(softwareName, true)
+	pdf.SetAutoPageBreak(false, 0)
+	pdf.SetMargins(0, 0, 0)
+	pdf.AddUTF8FontFromBytes(pdfFontFamily, "", font)
+
+	for i, p := range pages {
+		if p.Image == nil {
+			return fmt.Errorf("page %d: no image (extract with OCRConfig.KeepImage)", i+1)
+		}
+		data, imageType, err := pdfImage(p.Image)
+		if err != nil {
+			return fmt.Errorf("page %d: %w", i+1, err)
+		}
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("page %d: failed to read image size: %w", i+1, err)
+		}
+		width, height := float64(cfg.Width)*scale, float64(cfg.Height)*scale
+
+		pdf.AddPageFormat("P", gofpdf.SizeType{Wd: width, Ht: height})
+		name := fmt.Sprintf("page-%d", i+1)
+		pdf.RegisterImageOptionsReader(name, gofpdf.ImageOptions{ImageType: imageType}, bytes.NewReader(data))
+		pdf.ImageOptions(name, 0, 0, width, height, false, gofpdf.ImageOptions{ImageType: imageType}, 0, "")
+
+		if p.Page != nil {
+			writeTextLayer(pdf, p.Page, scale)
+		}
+		if err := pdf.Error(); err != nil {
+			return fmt.Errorf("page %d: %w", i+1, err)
+		}
+	}
+
+	return pdf.Output(w)
+}
+
+// writeTextLayer places the words of page as invisible text (rendering
+// mode 3), each sized to its box height and stretched to its box width.
+// Lines without a word tree are placed as a whole
+func writeTextLayer(pdf *gofpdf.Fpdf, page *Page, scale float64) {
+	pdf.SetTextRenderingMode(3)
+	defer pdf.SetTextRenderingMode(0)
+
+	for _, block := range page.Blocks {
+		for _, para := range block.Paragraphs {
+			for _, line := range para.Lines {
+				if len(line.Words) == 0 {
+					placeText(pdf, line.Text, line.Box, scale)
+					continue
+				}
+				for _, word := range line.Words {
+					placeText(pdf, word.Text, word.Box, scale)
+				}
+			}
+		}
+	}
+}
+
+// placeText writes text with its baseline near the bottom of box. The
+// Devanagari headline sits at the top of the box and matras hang below the
+// baseline, so the font size is the box height and the baseline 80% down
+func placeText(pdf *gofpdf.Fpdf, text string, box BoundingBox, scale float64) {
+	text = strings.TrimSpace(text)
+	if text == "" || box.Width <= 0 || box.Height <= 0 {
+		return
+	}
+	x, y := float64(box.X)*scale, float64(box.Y)*scale
+	w, h := float64(box.Width)*scale, float64(box.Height)*scale
+
+	pdf.SetFont(pdfFontFamily, "", h)
+	baseline := y + h*0.8
+
+	textWidth := pdf.GetStringWidth(text)
+	if textWidth <= 0 {
+		pdf.Text(x, baseline, text)
+		return
+	}
+	pdf.TransformBegin()
+	pdf.TransformScaleX(w/textWidth*100, x, baseline)
+	pdf.Text(x, baseline, text)
+	pdf.TransformEnd()
+}
+
+// pdfImage returns the page image in a form gofpdf embeds: 8-bit,
+// non-interlaced PNG or JPEG pass through unchanged, anything else (TIFF,
+// 16-bit PNG, ...) is decoded and re-encoded as 8-bit PNG
+func pdfImage(data []byte) ([]byte, string, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte("\xff\xd8\xff")):
+		return data, "JPG", nil
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")) && len(data) > 28 && data[24] <= 8 && data[28] == 0:
+		return data, "PNG", nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode page image: %w", err)
+	}
+	var out image.Image = img
+	switch img.(type) {
+	case *image.Gray, *image.RGBA, *image.NRGBA, *image.Paletted:
+	case *image.Gray16:
+		out = toGray(img)
+	default:
+		rgba := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+		draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+		out = rgba
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, out); err != nil {
+		return nil, "", fmt.Errorf("failed to encode page image: %w", err)
+	}
+	return buf.Bytes(), "PNG", nil
+}
+
+// pageImage encodes the page as its boxes see it: the source image, or
+// the grayscale page turned upright and straightened the same way as for
+// recognition when orientation detection or deskewing rotated it. The
+// other preprocessing steps keep the geometry and are left out, so the
+// page still looks like the scan
+func pageImage(src imageSource, o *Orientation, info *PreprocessInfo) ([]byte, error) {
+	rotated := o != nil && o.Applied
+	skewed := info != nil && info.SkewAngle != 0
+	if !rotated && !skewed {
+		return src.encoded()
+	}
+	img, err := src.decode()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	gray := toGray(img)
+	if rotated {
+		gray = rotateOrthogonal(gray, o.Angle)
+	}
+	if skewed {
+		gray = rotateGray(gray, info.SkewAngle)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, gray); err != nil {
+		return nil, fmt.Errorf("failed to encode image: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,187 @@
+package ocr
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+
+	// Register decoders so the page size can be read and images decoded for preprocessing
+	_ "image/jpeg"
+
+	"github.com/otiai10/gosseract/v2"
+)
+
+// imageSource is where the page image comes from: a file on disk or
+// encoded image bytes already in memory
+type imageSource struct {
+	path string
+	data []byte
+	// size is known up front for decoded images; otherwise it is read from the header
+	size image.Rectangle
+}
+
+// setOn hands the image to a Tesseract client
+func (s imageSource) setOn(client *gosseract.Client) error {
+	if s.data != nil {
+		return client.SetImageFromBytes(s.data)
+	}
+	return client.SetImage(s.path)
+}
+
+// bounds reads the image header for the page size.
+// An empty rectangle means the size is unknown and the page box falls back to its content
+func (s imageSource) bounds() image.Rectangle {
+	if !s.size.Empty() {
+		return s.size
+	}
+
+	var r io.Reader
+	if s.data != nil {
+		r = bytes.NewReader(s.data)
+	} else {
+		f, err := os.Open(s.path)
+		if err != nil {
+			return image.Rectangle{}
+		}
+		defer f.Close()
+		r = f
+	}
+
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return image.Rectangle{}
+	}
+	return image.Rect(0, 0, cfg.Width, cfg.Height)
+}
+
+// decode reads the whole image, e.g. for preprocessing
+func (s imageSource) decode() (image.Image, error) {
+	if s.data != nil {
+		img, _, err := image.Decode(bytes.NewReader(s.data))
+		return img, err
+	}
+
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	return img, err
+}
+
+// verify decodes the image in full and fails with ErrCorruptImage when it
+// is truncated or damaged. Images decoded in Go already and formats Go
+// cannot decode are let through for Leptonica to read
+func (s imageSource) verify() error {
+	if !s.size.Empty() {
+		return nil
+	}
+	_, err := s.pixels()
+	return err
+}
+
+// pixels decodes the image like verify, keeping the result. It is nil for
+// formats Go cannot decode
+func (s imageSource) pixels() (image.Image, error) {
+	img, err := s.decode()
+	if errors.Is(err, image.ErrFormat) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptImage, err)
+	}
+	return img, nil
+}
+
+// encoded returns the image file's bytes
+func (s imageSource) encoded() ([]byte, error) {
+	if s.data != nil {
+		return s.data, nil
+	}
+	return os.ReadFile(s.path)
+}
+
+// bytesSource wraps encoded image bytes. Leptonica is not always built
+// with WebP and GIF support, so those are decoded here and handed on as PNG
+func bytesSource(data []byte) (imageSource, error) {
+	if len(data) == 0 {
+		return imageSource{}, fmt.Errorf("image data is empty")
+	}
+	if format := DetectFormat(data); format == "webp" || format == "gif" {
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return imageSource{}, fmt.Errorf("%w: %v", ErrCorruptImage, err)
+		}
+		return decodedSource(img)
+	}
+	return imageSource{data: data}, nil
+}
+
+// readerSource reads encoded image bytes from r
+func readerSource(r io.Reader) (imageSource, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return imageSource{}, fmt.Errorf("failed to read image: %w", err)
+	}
+	return bytesSource(data)
+}
+
+// decodedSource re-encodes img as PNG because Tesseract reads through Leptonica
+func decodedSource(img image.Image) (imageSource, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return imageSource{}, fmt.Errorf("failed to encode image: %w", err)
+	}
+
+	// Leptonica reports positions from a zero origin, whatever img.Bounds() says
+	size := image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy())
+	return imageSource{data: buf.Bytes(), size: size}, nil
+}
+
+// ExtractFromBytes performs OCR on an encoded image (PNG, JPEG, TIFF, ...) held in memory
+func ExtractFromBytes(data []byte, config *OCRConfig) (*OCRResult, error) {
+	return ExtractFromBytesContext(context.Background(), data, config)
+}
+
+// ExtractFromBytesContext is ExtractFromBytes bounded by ctx
+func ExtractFromBytesContext(ctx context.Context, data []byte, config *OCRConfig) (*OCRResult, error) {
+	src, err := bytesSource(data)
+	if err != nil {
+		return nil, err
+	}
+	return extract(ctx, oneShot{}, src, config)
+}
+
+// ExtractFromReader reads an encoded image from r and performs OCR on it
+func ExtractFromReader(r io.Reader, config *OCRConfig) (*OCRResult, error) {
+	return ExtractFromReaderContext(context.Background(), r, config)
+}
+
+// ExtractFromReaderContext is ExtractFromReader bounded by ctx
+func ExtractFromReaderContext(ctx context.Context, r io.Reader, config *OCRConfig) (*OCRResult, error) {
+	src, err := readerSource(r)
+	if err != nil {
+		return nil, err
+	}
+	return extract(ctx, oneShot{}, src, config)
+}
+
+// ExtractFromDecodedImage performs OCR on an image the caller already decoded
+func ExtractFromDecodedImage(img image.Image, config *OCRConfig) (*OCRResult, error) {
+	return ExtractFromDecodedImageContext(context.Background(), img, config)
+}
+
+// ExtractFromDecodedImageContext is ExtractFromDecodedImage bounded by ctx
+func ExtractFromDecodedImageContext(ctx context.Context, img image.Image, config *OCRConfig) (*OCRResult, error) {
+	src, err := decodedSource(img)
+	if err != nil {
+		return nil, err
+	}
+	return extract(ctx, oneShot{}, src, config)
+}
@@ -0,0 +1,327 @@
+package ocr
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Spell correction defaults
+const (
+	DefaultSpellMaxDistance = 2.0
+	DefaultSpellMinGap      = 0.5
+	// shortWordClusters and below only get corrections one edit away
+	shortWordClusters = 2
+	// editPenalty scales a candidate's weight down for every edit
+	editPenalty = 0.01
+)
+
+// SpellConfig enables lexicon-driven correction of recognized words
+type SpellConfig struct {
+	// Lexicon of known words; correction is off when nil
+	Lexicon *Lexicon
+	// MaxDistance is the largest edit distance, counted in grapheme
+	// clusters, a correction may have (DefaultSpellMaxDistance when zero).
+	// Replacing a cluster by one with the same base letter costs 0.5
+	MaxDistance float64
+	// MinGap is how much of the candidates' total weight the best
+	// candidate must lead the runner-up by (DefaultSpellMinGap when zero)
+	MinGap float64
+}
+
+// Correction records a word the spell checker replaced
+type Correction struct {
+	Line       int     `json:"line"`
+	Original   string  `json:"original"`
+	Corrected  string  `json:"corrected"`
+	Distance   float64 `json:"distance"`
+	Confidence float64 `json:"confidence"`
+}
+
+// Lexicon is a list of known words and their frequencies, indexed for
+// fast lookup of words within an edit distance
+type Lexicon struct {
+	freq map[string]int
+	root *bkNode
+}
+
+// bkNode is a BK-tree node; children are keyed by their distance to word
+type bkNode struct {
+	word     string
+	clusters []string
+	children map[int]*bkNode
+}
+
+// NewLexicon builds a lexicon from words and their frequencies
+func NewLexicon(freq map[string]int) *Lexicon {
+	l := &Lexicon{freq: make(map[string]int, len(freq))}
+	for word, n := range freq {
+		l.add(word, n)
+	}
+	return l
+}
+
+// LoadLexicon reads a wordlist file; see ReadLexicon for the format
+func LoadLexicon(path string) (*Lexicon, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lexicon: %w", err)
+	}
+	defer f.Close()
+	return ReadLexicon(f)
+}
+
+// ReadLexicon reads one word per line, optionally followed by whitespace
+// and its frequency (1 when missing). Empty lines and lines starting with
+// # are skipped; repeated words add up
+func ReadLexicon(r io.Reader) (*Lexicon, error) {
+	l := &Lexicon{freq: make(map[string]int)}
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		n := 1
+		if len(fields) > 1 {
+			var err error
+			n, err = strconv.Atoi(fields[1])
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("lexicon line %d: invalid frequency %q", lineNum, fields[1])
+			}
+		}
+		l.add(fields[0], n)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read lexicon: %w", err)
+	}
+	return l, nil
+}
+
+// Len returns the number of distinct words
+func (l *Lexicon) Len() int {
+	return len(l.freq)
+}
+
+// Contains reports whether word is in the lexicon
+func (l *Lexicon) Contains(word string) bool {
+	_, ok := l.freq[word]
+	return ok
+}
+
+func (l *Lexicon) add(word string, n int) {
+	if _, ok := l.freq[word]; ok {
+		l.freq[word] += n
+		return
+	}
+	l.freq[word] = n
+
+	node := &bkNode{word: word, clusters: graphemes(word)}
+	if l.root == nil {
+		l.root = node
+		return
+	}
+	for cur := l.root; ; {
+		d := clusterDistance(cur.clusters, node.clusters)
+		next, ok := cur.children[d]
+		if !ok {
+			if cur.children == nil {
+				cur.children = make(map[int]*bkNode)
+			}
+			cur.children[d] = node
+			return
+		}
+		cur = next
+	}
+}
+
+// candidate is a lexicon word near the word being corrected
+type candidate struct {
+	word     string
+	distance int // in half edits
+	weight   float64
+}
+
+// search returns every word within maxDist half edits of clusters
+func (l *Lexicon) search(clusters []string, maxDist int) []candidate {
+	if l.root == nil {
+		return nil
+	}
+	var found []candidate
+	stack := []*bkNode{l.root}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		d := clusterDistance(node.clusters, clusters)
+		if d <= maxDist {
+			weight := float64(l.freq[node.word]+1) * math.Pow(editPenalty, float64(d)/2)
+			found = append(found, candidate{word: node.word, distance: d, weight: weight})
+		}
+		// Triangle inequality: only children in [d-maxDist, d+maxDist] can match
+		for cd, child := range node.children {
+			if cd >= d-maxDist && cd <= d+maxDist {
+				stack = append(stack, child)
+			}
+		}
+	}
+	return found
+}
+
+// correct proposes a replacement for one word. It returns false when the
+// word is known, has no candidates or the best one does not lead clearly
+func (s SpellConfig) correct(word string) (Correction, bool) {
+	if s.Lexicon.Contains(word) {
+		return Correction{}, false
+	}
+	clusters := graphemes(word)
+	if len(clusters) < 2 {
+		return Correction{}, false
+	}
+
+	maxDist := s.MaxDistance
+	if maxDist <= 0 {
+		maxDist = DefaultSpellMaxDistance
+	}
+	if len(clusters) <= shortWordClusters {
+		maxDist = math.Min(maxDist, 1)
+	}
+	minGap := s.MinGap
+	if minGap <= 0 {
+		minGap = DefaultSpellMinGap
+	}
+
+	candidates := s.Lexicon.search(clusters, int(maxDist*2))
+	if len(candidates) == 0 {
+		return Correction{}, false
+	}
+
+	var total float64
+	best, second := -1, -1
+	for i, c := range candidates {
+		total += c.weight
+		switch {
+		case best < 0 || c.weight > candidates[best].weight:
+			best, second = i, best
+		case second < 0 || c.weight > candidates[second].weight:
+			second = i
+		}
+	}
+
+	gap := candidates[best].weight
+	if second >= 0 {
+		gap -= candidates[second].weight
+	}
+	if gap/total < minGap {
+		return Correction{}, false
+	}
+
+	return Correction{
+		Original:   word,
+		Corrected:  candidates[best].word,
+		Distance:   float64(candidates[best].distance) / 2,
+		Confidence: math.Round(candidates[best].weight/total*1000) / 10,
+	}, true
+}
+
+// correctText corrects every Devanagari word of text, keeping punctuation
+// around words in place, and returns the corrections it made
+func (s SpellConfig) correctText(text string) (string, []Correction) {
+	var corrections []Correction
+	tokens := strings.Fields(text)
+	for i, token := range tokens {
+		word := strings.TrimFunc(token, isWordPunct)
+		if word == "" || !isDevanagariWord(word) {
+			continue
+		}
+		c, ok := s.correct(word)
+		if !ok {
+			continue
+		}
+		tokens[i] = strings.Replace(token, word, c.Corrected, 1)
+		corrections = append(corrections, c)
+	}
+	if len(corrections) == 0 {
+		return text, nil
+	}
+	return strings.Join(tokens, " "), corrections
+}
+
+// isWordPunct matches punctuation that may be attached to a word
+func isWordPunct(r rune) bool {
+	return r == '।' || r == '॥' || unicode.IsPunct(r)
+}
+
+// isDevanagariWord reports whether word consists only of Devanagari letters and signs
+func isDevanagariWord(word string) bool {
+	for _, r := range word {
+		if (r < 'ऀ' || r > 'ॣ') && r != '\u200C' && r != '\u200D' {
+			return false
+		}
+	}
+	return true
+}
+
+// graphemes splits a word into user-perceived characters. Combining marks
+// (matras, nukta, virama, candrabindu) join the letter before them and a
+// virama joins the next consonant, so a conjunct such as "प्र" is one cluster
+func graphemes(word string) []string {
+	var clusters []string
+	var cur []rune
+	for _, r := range word {
+		joins := unicode.Is(unicode.M, r) || r == '\u200C' || r == '\u200D'
+		if n := len(cur); n > 0 && cur[n-1] == '्' {
+			joins = true
+		}
+		if !joins && len(cur) > 0 {
+			clusters = append(clusters, string(cur))
+			cur = cur[:0]
+		}
+		cur = append(cur, r)
+	}
+	if len(cur) > 0 {
+		clusters = append(clusters, string(cur))
+	}
+	return clusters
+}
+
+// clusterDistance is the Levenshtein distance between two cluster
+// sequences in half edits: inserting, deleting or replacing a cluster costs
+// 2, replacing it by one with the same base letter (another matra, a
+// missing nukta) costs 1
+func clusterDistance(a, b []string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = 2 * j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = 2 * i
+		for j := 1; j <= len(b); j++ {
+			sub := 0
+			if a[i-1] != b[j-1] {
+				sub = 2
+				if sameBase(a[i-1], b[j-1]) {
+					sub = 1
+				}
+			}
+			cur[j] = min(prev[j]+2, cur[j-1]+2, prev[j-1]+sub)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// sameBase reports whether two clusters start with the same letter
+func sameBase(a, b string) bool {
+	ra, rb := []rune(a), []rune(b)
+	return len(ra) > 0 && len(rb) > 0 && ra[0] == rb[0]
+}
@@ -0,0 +1,90 @@
+package ocr
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGraphemes(t *testing.T) {
+	tests := []struct {
+		word string
+		want []string
+	}{
+		{"नेपाल", []string{"ने", "पा", "ल"}},
+		{"प्रदेश", []string{"प्र", "दे", "श"}},
+		{"क़ानून", []string{"क़ा", "नू", "न"}},
+		{"सँग", []string{"सँ", "ग"}},
+	}
+	for _, tt := range tests {
+		if got := graphemes(tt.word); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("graphemes(%q) = %q, want %q", tt.word, got, tt.want)
+		}
+	}
+}
+
+func TestClusterDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"नेपाल", "नेपाल", 0},
+		{"नेपाल", "नेपल", 1},    // missing matra: half an edit
+		{"कलम", "कमल", 4},       // two letters replaced
+		{"प्रदेश", "पदेश", 1},   // conjunct read as its first letter
+		{"नेपाल", "नेपालमा", 2}, // one cluster added
+	}
+	for _, tt := range tests {
+		if got := clusterDistance(graphemes(tt.a), graphemes(tt.b)); got != tt.want {
+			t.Errorf("clusterDistance(%q, %q) = %d half edits, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestSpellCorrect(t *testing.T) {
+	tests := []struct {
+		name    string
+		lexicon map[string]int
+		word    string
+		want    string // empty when the word is left alone
+		dist    float64
+	}{
+		{"missing matra is corrected", map[string]int{"नेपाल": 100, "काठमाडौं": 50}, "नेपल", "नेपाल", 0.5},
+		{"known word is left alone", map[string]int{"नेपाल": 100, "नेपालि": 5000}, "नेपाल", "", 0},
+		{"tie between candidates is refused", map[string]int{"कलम": 10, "कमल": 10}, "कमम", "", 0},
+		{"close runner-up is refused", map[string]int{"कलम": 10, "कमल": 15}, "कमम", "", 0},
+		{"frequent candidate wins", map[string]int{"कलम": 1, "कमल": 1000}, "कमम", "कमल", 1},
+		{"nearer candidate wins", map[string]int{"नेपाल": 10, "नेवार": 10}, "नेपल", "नेपाल", 0.5},
+		{"too far is left alone", map[string]int{"नेपाल": 100}, "भारत", "", 0},
+		{"short word gets one edit at most", map[string]int{"घर": 100}, "बन", "", 0},
+		{"single cluster is left alone", map[string]int{"म": 100}, "त", "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spell := SpellConfig{Lexicon: NewLexicon(tt.lexicon)}
+			got, ok := spell.correct(tt.word)
+			if tt.want == "" {
+				if ok {
+					t.Errorf("correct(%q) = %q, want no correction", tt.word, got.Corrected)
+				}
+				return
+			}
+			if !ok {
+				t.Fatalf("correct(%q) made no correction, want %q", tt.word, tt.want)
+			}
+			if got.Corrected != tt.want || got.Distance != tt.dist {
+				t.Errorf("correct(%q) = %q at %v, want %q at %v", tt.word, got.Corrected, got.Distance, tt.want, tt.dist)
+			}
+		})
+	}
+}
+
+func TestSpellCorrectText(t *testing.T) {
+	spell := SpellConfig{Lexicon: NewLexicon(map[string]int{"नेपाल": 100, "सुन्दर": 40, "देश": 80})}
+	got, corrections := spell.correctText("नेपल सुन्दर देस। Nepal")
+	if want := "नेपाल सुन्दर देश। Nepal"; got != want {
+		t.Errorf("correctText() = %q, want %q", got, want)
+	}
+	if len(corrections) != 2 || corrections[0].Original != "नेपल" || corrections[1].Original != "देस" {
+		t.Errorf("corrections = %+v, want नेपल and देस", corrections)
+	}
+}
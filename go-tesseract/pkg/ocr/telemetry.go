@@ -0,0 +1,61 @@
+package ocr
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of every extraction. It does nothing until the
+// program installs a tracer provider with otel.SetTracerProvider
+var tracer = otel.Tracer("github.com/ToniBirat7/tesseract_ocr_ne/pkg/ocr")
+
+// Stage is one step of an extraction, timed with OCRConfig.OnStage
+type Stage string
+
+const (
+	// StagePreprocess covers orientation detection and image preprocessing
+	StagePreprocess Stage = "preprocess"
+	// StageAcquire is the wait for a Tesseract client from the pool
+	StageAcquire Stage = "acquire"
+	// StageRecognize is the Tesseract recognition itself
+	StageRecognize Stage = "recognize"
+	// StageClean covers text filters, spell correction and the page tree
+	StageClean Stage = "clean"
+)
+
+// startStage opens a child span for stage; the returned function ends it
+// and reports the elapsed time to config.OnStage
+func startStage(ctx context.Context, config *OCRConfig, stage Stage) func(err error) {
+	start := time.Now()
+	_, span := tracer.Start(ctx, "ocr."+string(stage))
+	return func(err error) {
+		endSpan(span, err)
+		if config.OnStage != nil {
+			config.OnStage(stage, time.Since(start))
+		}
+	}
+}
+
+// startExtract opens the span of one extraction with its settings
+func startExtract(ctx context.Context, config *OCRConfig) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "ocr.Extract", trace.WithAttributes(
+		attribute.String("ocr.languages", strings.Join(config.languages(), "+")),
+		attribute.Int("ocr.page_seg_mode", config.PageSegMode),
+		attribute.String("ocr.granularity", string(config.Granularity)),
+	))
+}
+
+// endSpan records err, if any, and ends span
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,125 @@
+package ocr
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+
+	// Registers TIFF (including CCITT G3/G4) with the image package
+	"golang.org/x/image/tiff"
+)
+
+// maxTIFFPages guards against corrupt files whose IFD chain loops or runs away
+const maxTIFFPages = 10000
+
+// IsTIFF reports whether data starts with a TIFF header
+func IsTIFF(data []byte) bool {
+	return len(data) >= 8 && (bytes.HasPrefix(data, []byte("II*\x00")) || bytes.HasPrefix(data, []byte("MM\x00*")))
+}
+
+// tiffFrames walks the IFD chain and returns the offset of every frame (page)
+func tiffFrames(data []byte) (binary.ByteOrder, []uint32, error) {
+	if !IsTIFF(data) {
+		return nil, nil, fmt.Errorf("not a tiff file")
+	}
+
+	var order binary.ByteOrder = binary.LittleEndian
+	if data[0] == 'M' {
+		order = binary.BigEndian
+	}
+
+	var offsets []uint32
+	seen := make(map[uint32]bool)
+	next := order.Uint32(data[4:8])
+
+	for next != 0 {
+		if seen[next] || len(offsets) >= maxTIFFPages {
+			return nil, nil, fmt.Errorf("tiff IFD chain loops or is too long")
+		}
+		if int(next)+2 > len(data) {
+			return nil, nil, fmt.Errorf("tiff IFD offset %d out of range", next)
+		}
+		seen[next] = true
+		offsets = append(offsets, next)
+
+		// An IFD is a 2-byte entry count, 12 bytes per entry, then the next IFD offset
+		entries := int(order.Uint16(data[next : next+2]))
+		end := int(next) + 2 + entries*12
+		if end+4 > len(data) {
+			return nil, nil, fmt.Errorf("tiff IFD at %d is truncated", next)
+		}
+		next = order.Uint32(data[end : end+4])
+	}
+
+	if len(offsets) == 0 {
+		return nil, nil, fmt.Errorf("tiff has no pages")
+	}
+	return order, offsets, nil
+}
+
+// ExtractFromTIFF performs OCR on every frame of a (multi-page) TIFF file
+func ExtractFromTIFF(tiffPath string, config *OCRConfig) (*DocumentResult, error) {
+	return ExtractFromTIFFContext(context.Background(), tiffPath, config)
+}
+
+// ExtractFromTIFFContext is ExtractFromTIFF bounded by ctx
+func ExtractFromTIFFContext(ctx context.Context, tiffPath string, config *OCRConfig) (*DocumentResult, error) {
+	data, err := os.ReadFile(tiffPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tiff: %w", err)
+	}
+	return extractTIFF(ctx, oneShot{}, data, config)
+}
+
+// ExtractFromTIFF performs OCR on every frame of a TIFF file using pooled clients
+func (e *Engine) ExtractFromTIFF(ctx context.Context, tiffPath string, config *OCRConfig) (*DocumentResult, error) {
+	data, err := os.ReadFile(tiffPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tiff: %w", err)
+	}
+	return extractTIFF(ctx, e, data, config)
+}
+
+// ExtractFromTIFFBytes is ExtractFromTIFF for a TIFF held in memory
+func (e *Engine) ExtractFromTIFFBytes(ctx context.Context, data []byte, config *OCRConfig) (*DocumentResult, error) {
+	return extractTIFF(ctx, e, data, config)
+}
+
+// extractTIFF decodes one frame at a time in Go, which handles CCITT G4 even
+// when Leptonica was built without libtiff. The decoder only reads the first
+// IFD, so a private copy of the file has its header pointed at each frame in
+// turn. A frame's header is held to config.ImageLimits before its pixels are
+// decoded, since a small compressed frame can expand to gigabytes
+func extractTIFF(ctx context.Context, clients clientProvider, data []byte, config *OCRConfig) (*DocumentResult, error) {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	order, offsets, err := tiffFrames(data)
+	if err != nil {
+		return nil, err
+	}
+	if config.MaxPages > 0 && len(offsets) > config.MaxPages {
+		return nil, fmt.Errorf("%w: the TIFF has %d pages, a PDF or TIFF may have at most %d", ErrTooManyPages, len(offsets), config.MaxPages)
+	}
+
+	buf := make([]byte, len(data))
+	copy(buf, data)
+
+	return extractPages(ctx, clients, len(offsets), func(i int) (imageSource, error) {
+		order.PutUint32(buf[4:8], offsets[i])
+		cfg, err := tiff.DecodeConfig(bytes.NewReader(buf))
+		if err != nil {
+			return imageSource{}, fmt.Errorf("%w: tiff frame: %v", ErrCorruptImage, err)
+		}
+		if err := config.ImageLimits.check(cfg.Width, cfg.Height); err != nil {
+			return imageSource{}, err
+		}
+		img, err := tiff.Decode(bytes.NewReader(buf))
+		if err != nil {
+			return imageSource{}, fmt.Errorf("%w: tiff frame: %v", ErrCorruptImage, err)
+		}
+		return decodedSource(img)
+	}, config)
+}
@@ -0,0 +1,204 @@
+package ocr
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// TIFF tags patched by the tests
+const (
+	tagImageWidth  = 256
+	tagImageLength = 257
+)
+
+// firstIFD returns the offset of the first IFD of a little endian TIFF
+func firstIFD(data []byte) int {
+	return int(binary.LittleEndian.Uint32(data[4:8]))
+}
+
+// nextIFDField returns where the next-IFD offset of the IFD at ifd is stored
+func nextIFDField(data []byte, ifd int) int {
+	return ifd + 2 + int(binary.LittleEndian.Uint16(data[ifd:]))*12
+}
+
+// addPage appends a copy of the first IFD to a TIFF written by tiff.Encode
+// and links it as the last page. Its strips point at the first page's
+// pixels, which the copy shares
+func addPage(data []byte) []byte {
+	first := firstIFD(data)
+	ifd := append([]byte(nil), data[first:nextIFDField(data, first)]...)
+
+	last := first
+	for next := binary.LittleEndian.Uint32(data[nextIFDField(data, last):]); next != 0; {
+		last = int(next)
+		next = binary.LittleEndian.Uint32(data[nextIFDField(data, last):])
+	}
+	if len(data)%2 == 1 {
+		data = append(data, 0)
+	}
+	binary.LittleEndian.PutUint32(data[nextIFDField(data, last):], uint32(len(data)))
+	data = append(data, ifd...)
+	return append(data, 0, 0, 0, 0)
+}
+
+// setTag overwrites the value of a SHORT or LONG tag in the IFD at ifd
+func setTag(t *testing.T, data []byte, ifd int, tag uint16, value uint32) {
+	t.Helper()
+	n := int(binary.LittleEndian.Uint16(data[ifd:]))
+	for i := 0; i < n; i++ {
+		entry := data[ifd+2+i*12:]
+		if binary.LittleEndian.Uint16(entry) != tag {
+			continue
+		}
+		if binary.LittleEndian.Uint16(entry[2:]) == 3 {
+			binary.LittleEndian.PutUint16(entry[8:], uint16(value))
+		} else {
+			binary.LittleEndian.PutUint32(entry[8:], value)
+		}
+		return
+	}
+	t.Fatalf("tag %d not found", tag)
+}
+
+// tiffWithSize makes the header of page i of data claim w x h pixels
+func tiffWithSize(t *testing.T, data []byte, page, w, h int) []byte {
+	t.Helper()
+	data = append([]byte(nil), data...)
+	ifd := firstIFD(data)
+	for ; page > 0; page-- {
+		ifd = int(binary.LittleEndian.Uint32(data[nextIFDField(data, ifd):]))
+	}
+	setTag(t, data, ifd, tagImageWidth, uint32(w))
+	setTag(t, data, ifd, tagImageLength, uint32(h))
+	return data
+}
+
+// emptyIFDChain is a TIFF header followed by n IFDs without entries, each
+// pointing at the next
+func emptyIFDChain(n int) []byte {
+	data := []byte("II*\x00\x08\x00\x00\x00")
+	for i := 0; i < n; i++ {
+		next := uint32(len(data) + 6)
+		if i == n-1 {
+			next = 0
+		}
+		data = binary.LittleEndian.AppendUint16(data, 0)
+		data = binary.LittleEndian.AppendUint32(data, next)
+	}
+	return data
+}
+
+func TestTIFFFrames(t *testing.T) {
+	page := encodeTIFF(t, 30, 20)
+	twoPages := addPage(append([]byte(nil), page...))
+
+	loop := append([]byte(nil), twoPages...)
+	second := binary.LittleEndian.Uint32(loop[nextIFDField(loop, firstIFD(loop)):])
+	binary.LittleEndian.PutUint32(loop[nextIFDField(loop, int(second)):], uint32(firstIFD(loop)))
+
+	selfLoop := append([]byte(nil), page...)
+	binary.LittleEndian.PutUint32(selfLoop[nextIFDField(selfLoop, firstIFD(selfLoop)):], uint32(firstIFD(selfLoop)))
+
+	outOfRange := append([]byte(nil), twoPages...)
+	binary.LittleEndian.PutUint32(outOfRange[nextIFDField(outOfRange, firstIFD(outOfRange)):], uint32(len(outOfRange)+100))
+
+	truncated := append([]byte(nil), page...)
+	binary.LittleEndian.PutUint32(truncated[4:8], uint32(len(truncated)-4))
+
+	tests := []struct {
+		name  string
+		data  []byte
+		pages int
+		err   string
+	}{
+		{name: "one page", data: page, pages: 1},
+		{name: "two pages", data: twoPages, pages: 2},
+		{name: "three pages", data: addPage(append([]byte(nil), twoPages...)), pages: 3},
+		{name: "chain at the limit", data: emptyIFDChain(maxTIFFPages), pages: maxTIFFPages},
+		{name: "chain over the limit", data: emptyIFDChain(maxTIFFPages + 1), err: "loops or is too long"},
+		{name: "next IFD loops back", data: loop, err: "loops or is too long"},
+		{name: "IFD points at itself", data: selfLoop, err: "loops or is too long"},
+		{name: "next IFD out of range", data: outOfRange, err: "out of range"},
+		{name: "IFD runs past the end", data: truncated, err: "truncated"},
+		{name: "no pages", data: []byte("II*\x00\x00\x00\x00\x00"), err: "no pages"},
+		{name: "not a tiff", data: encodePNG(t, 2, 2), err: "not a tiff"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, offsets, err := tiffFrames(tt.data)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("tiffFrames() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("tiffFrames() error = %v", err)
+			}
+			if len(offsets) != tt.pages {
+				t.Errorf("tiffFrames() = %d pages, want %d", len(offsets), tt.pages)
+			}
+		})
+	}
+}
+
+func TestInspectTIFF(t *testing.T) {
+	limits := ImageLimits{MaxSide: 1000, MaxPixels: 500_000}
+	twoPages := addPage(encodeTIFF(t, 30, 20))
+
+	tests := []struct {
+		name  string
+		data  []byte
+		pages int
+		err   error
+	}{
+		{name: "two pages", data: twoPages, pages: 2},
+		{name: "first page over the limits", data: tiffWithSize(t, twoPages, 0, 2000, 20), err: ErrImageTooLarge},
+		{name: "second page over the limits", data: tiffWithSize(t, twoPages, 1, 1000, 1000), err: ErrImageTooLarge},
+		{name: "page without pixels", data: tiffWithSize(t, twoPages, 1, 0, 20), err: ErrCorruptImage},
+		{name: "runaway IFD chain", data: emptyIFDChain(maxTIFFPages + 1), err: ErrCorruptImage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := Inspect(tt.data, limits)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("Inspect() error = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Inspect() error = %v", err)
+			}
+			if info.Pages != tt.pages {
+				t.Errorf("Inspect() = %d pages, want %d", info.Pages, tt.pages)
+			}
+		})
+	}
+}
+
+// The checks below fail before a Tesseract client is asked for
+func TestExtractTIFFLimits(t *testing.T) {
+	twoPages := addPage(encodeTIFF(t, 30, 20))
+
+	tests := []struct {
+		name   string
+		data   []byte
+		config *OCRConfig
+		err    error
+	}{
+		{"more pages than MaxPages", twoPages, &OCRConfig{MaxPages: 1}, ErrTooManyPages},
+		{"frame header over ImageLimits", tiffWithSize(t, twoPages, 0, 5000, 5000), &OCRConfig{ImageLimits: ImageLimits{MaxPixels: 1_000_000}}, ErrImageTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := extractTIFF(context.Background(), oneShot{}, tt.data, tt.config)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("extractTIFF() error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
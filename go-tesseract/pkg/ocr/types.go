@@ -1,9 +1,86 @@
 package ocr
 
+import (
+	"fmt"
+	"image"
+	"strings"
+	"time"
+
+	"github.com/ToniBirat7/tesseract_ocr_ne/pkg/textfilter"
+)
+
+// BoundingBox is a pixel rectangle in image coordinates
+type BoundingBox struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// newBoundingBox converts an image.Rectangle into a BoundingBox
+func newBoundingBox(r image.Rectangle) BoundingBox {
+	return BoundingBox{X: r.Min.X, Y: r.Min.Y, Width: r.Dx(), Height: r.Dy()}
+}
+
+// Rect returns the box as an image.Rectangle
+func (b BoundingBox) Rect() image.Rectangle {
+	return image.Rect(b.X, b.Y, b.X+b.Width, b.Y+b.Height)
+}
+
 // ExtractedLine represents a single line of OCR text with its confidence
 type ExtractedLine struct {
-	Text       string  `json:"text"`
-	Confidence float64 `json:"confidence"`
+	Text       string      `json:"text"`
+	Confidence float64     `json:"confidence"`
+	Box        BoundingBox `json:"box"`
+	Script     Script      `json:"script,omitempty"`
+	Spans      []Span      `json:"spans,omitempty"`
+}
+
+// Word is a single recognized word
+type Word struct {
+	Index      int         `json:"index"`
+	Text       string      `json:"text"`
+	Confidence float64     `json:"confidence"`
+	Box        BoundingBox `json:"box"`
+	Script     Script      `json:"script,omitempty"`
+}
+
+// Line is a text line made of words
+type Line struct {
+	Index      int         `json:"index"`
+	Text       string      `json:"text"`
+	Confidence float64     `json:"confidence"`
+	Box        BoundingBox `json:"box"`
+	Script     Script      `json:"script,omitempty"`
+	Words      []Word      `json:"words,omitempty"`
+}
+
+// Paragraph is a group of lines inside a block
+type Paragraph struct {
+	Index      int         `json:"index"`
+	Text       string      `json:"text"`
+	Confidence float64     `json:"confidence"`
+	Box        BoundingBox `json:"box"`
+	Lines      []Line      `json:"lines,omitempty"`
+}
+
+// Block is a region of text as segmented by Tesseract
+type Block struct {
+	Index      int         `json:"index"`
+	Text       string      `json:"text"`
+	Confidence float64     `json:"confidence"`
+	Box        BoundingBox `json:"box"`
+	Paragraphs []Paragraph `json:"paragraphs,omitempty"`
+}
+
+// Page is the root of the page -> block -> paragraph -> line -> word tree.
+// Its box covers the whole image
+type Page struct {
+	Index      int         `json:"index"`
+	Text       string      `json:"text"`
+	Confidence float64     `json:"confidence"`
+	Box        BoundingBox `json:"box"`
+	Blocks     []Block     `json:"blocks,omitempty"`
 }
 
 // OCRResult represents the complete OCR extraction result
@@ -12,14 +89,144 @@ type OCRResult struct {
 	AverageConfidence float64         `json:"average_confidence"`
 	LineCount         int             `json:"line_count"`
 	Lines             []ExtractedLine `json:"lines,omitempty"`
+	Page              *Page           `json:"page,omitempty"`
+	Corrections       []Correction    `json:"corrections,omitempty"`
+	Orientation       *Orientation    `json:"orientation,omitempty"`
+	Preprocessing     *PreprocessInfo `json:"preprocessing,omitempty"`
+	// Image is the encoded page image the boxes refer to, kept with OCRConfig.KeepImage
+	Image []byte `json:"-"`
+	// Pixels is the decoded page image, kept with OCRConfig.KeepPixels
+	Pixels image.Image `json:"-"`
+
+	// recognized is the number of lines AverageConfidence was taken over,
+	// before MinConfidence and the filters dropped any
+	recognized int
+}
+
+// Granularity selects how deep the page tree in OCRResult goes.
+// The empty value skips the tree and only returns flat lines
+type Granularity string
+
+const (
+	GranularityNone      Granularity = ""
+	GranularityPage      Granularity = "page"
+	GranularityBlock     Granularity = "block"
+	GranularityParagraph Granularity = "paragraph"
+	GranularityLine      Granularity = "line"
+	GranularityWord      Granularity = "word"
+)
+
+// ParseGranularity validates a granularity name coming from flags or form values
+func ParseGranularity(s string) (Granularity, error) {
+	switch g := Granularity(s); g {
+	case GranularityNone, GranularityPage, GranularityBlock, GranularityParagraph, GranularityLine, GranularityWord:
+		return g, nil
+	}
+	return GranularityNone, fmt.Errorf("unknown granularity %q (use page, block, paragraph, line or word)", s)
+}
+
+// ParseLanguages splits a language list such as "nep+eng" or "nep,eng"
+// coming from flags or form values into Tesseract language codes
+func ParseLanguages(s string) ([]string, error) {
+	var langs []string
+	for _, lang := range strings.FieldsFunc(s, func(r rune) bool { return r == '+' || r == ',' }) {
+		lang = strings.TrimSpace(lang)
+		for _, r := range lang {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_') {
+				return nil, fmt.Errorf("invalid language code %q", lang)
+			}
+		}
+		if lang != "" {
+			langs = append(langs, lang)
+		}
+	}
+	if len(langs) == 0 {
+		return nil, fmt.Errorf("no language given (e.g. nep or nep+eng)")
+	}
+	return langs, nil
 }
 
 // OCRConfig holds configuration for OCR processing
 type OCRConfig struct {
-	Language        string
+	Language string
+	// Languages loads several traineddata files at once, e.g. nep and eng
+	// for pages mixing Nepali with English terms. It overrides Language
+	Languages       []string
 	IncludeLines    bool
 	CleanDevanagari bool
 	MinConfidence   float64
+	Granularity     Granularity
+	// PageSegMode is a Tesseract PSM (see gosseract.PageSegMode).
+	// Zero keeps Tesseract's default
+	PageSegMode int
+	// PDFDPI is the resolution PDF pages are rasterized at (DefaultPDFDPI when zero)
+	PDFDPI int
+	// MaxPages rejects longer PDFs and multi-page TIFFs with
+	// ErrTooManyPages before any page is rasterized or decoded (no limit
+	// when zero)
+	MaxPages int
+	// Rasterizer renders PDF pages (PdftoppmRasterizer when nil)
+	Rasterizer Rasterizer
+	// ImageLimits bounds every page, read from its header before anything
+	// else happens to it, so pages rasterized from a PDF are held to the
+	// limits Inspect applies to uploads; with a PageSizer they are measured
+	// before they are rendered. Pages over them fail with ErrImageTooLarge.
+	// DefaultConfig sets none; servers taking untrusted files should
+	ImageLimits ImageLimits
+	// VerifyImage decodes encoded images in full once a client is taken,
+	// so a truncated or damaged file fails with ErrCorruptImage rather than
+	// in Leptonica. Pooled, no more images are decoded at once than the
+	// pool has clients
+	VerifyImage bool
+	// Preprocess cleans up the image before recognition (nothing when zero)
+	Preprocess PreprocessConfig
+	// DetectOrientation guesses whether the page is turned by 90, 180 or
+	// 270 degrees and rotates it upright when the confidence reaches
+	// OrientationConfidence (DefaultOrientationConfidence when zero)
+	DetectOrientation     bool
+	OrientationConfidence float64
+	// Filters post-process every line and word in order. When nil,
+	// CleanDevanagari selects textfilter.DevanagariCleanup, or a cleanup
+	// that also keeps Latin letters when English is among Languages
+	Filters textfilter.Chain
+	// NormalizeNepali runs textfilter.NepaliNormalizer ahead of Filters
+	NormalizeNepali bool
+	// Spell corrects words against a lexicon after filtering (off when Spell.Lexicon is nil)
+	Spell SpellConfig
+	// KeepImage keeps the page image in OCRResult.Image, e.g. for WriteSearchablePDF
+	KeepImage bool
+	// KeepPixels keeps the decoded image in OCRResult.Pixels, e.g. for
+	// layout analysis. It is decoded while the Tesseract client is held, and
+	// only when the page was neither rotated nor deskewed, since its pixels
+	// would no longer match the boxes. Pages of a PDF or TIFF do not keep theirs
+	KeepPixels bool
+	// Progress is called after every page of a PDF or TIFF with the number
+	// of pages done and the page count
+	Progress func(done, total int)
+	// OnPage is called with every page of a PDF or TIFF as soon as it is
+	// recognized, numbered from 1 of count, before Progress
+	OnPage func(number, count int, result *OCRResult)
+	// OnStage is called with the time every Stage of an extraction took,
+	// e.g. to feed latency metrics
+	OnStage func(stage Stage, elapsed time.Duration)
+}
+
+// textFilter returns the filter applied to recognized text, or nil for none
+func (c *OCRConfig) textFilter() textfilter.TextFilter {
+	var chain textfilter.Chain
+	if c.NormalizeNepali {
+		chain = append(chain, textfilter.NepaliNormalizer{})
+	}
+	switch {
+	case c.Filters != nil:
+		chain = append(chain, c.Filters...)
+	case c.CleanDevanagari:
+		chain = append(chain, c.cleanup()...)
+	}
+	if len(chain) == 0 {
+		return nil
+	}
+	return chain
 }
 
 // DefaultConfig returns the default OCR configuration for Nepali text
@@ -29,5 +236,6 @@ func DefaultConfig() *OCRConfig {
 		IncludeLines:    false,
 		CleanDevanagari: true,
 		MinConfidence:   0.0,
+		Granularity:     GranularityNone,
 	}
-}
\ No newline at end of file
+}
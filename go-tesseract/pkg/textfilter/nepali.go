@@ -0,0 +1,198 @@
+package textfilter
+
+import (
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Devanagari code points the Nepali rules look at
+const (
+	candrabindu = 'ँ'
+	anusvara    = 'ं'
+	nukta       = '़'
+	virama      = '्'
+	signAA      = 'ा'
+	signI       = 'ि'
+	signE       = 'े'
+	signAI      = 'ै'
+	signO       = 'ो'
+	signAU      = 'ौ'
+	danda       = '।'
+	doubleDanda = '॥'
+	zwnj        = '\u200C'
+	zwj         = '\u200D'
+)
+
+// matraPairs maps two vowel signs Tesseract emits for a single one
+var matraPairs = map[[2]rune]rune{
+	{signAA, signE}:  signO,
+	{signE, signAA}:  signO,
+	{signAA, signAI}: signAU,
+	{signAI, signAA}: signAU,
+}
+
+// vowelPairs maps an independent vowel and a vowel sign written for the
+// independent vowel they spell together, e.g. "अा" for "आ"
+var vowelPairs = map[[2]rune]rune{
+	{'अ', signAA}: 'आ',
+	{'अ', signO}:  'ओ',
+	{'अ', signAU}: 'औ',
+	{'आ', signE}:  'ओ',
+	{'आ', signAI}: 'औ',
+	{'ए', signE}:  'ऐ',
+}
+
+// NepaliNormalizer repairs the Unicode artifacts Tesseract's nep model
+// leaves in its output. Run it before any filter that removes "|":
+//
+//   - invisible characters (zero width space, BOM, soft hyphen) are removed,
+//     and ZWJ/ZWNJ are kept only after a virama, where they shape conjuncts
+//   - the text is put in NFC, so nukta letters are always base + nukta
+//   - repeated nukta, virama, vowel signs and candrabindu/anusvara collapse
+//     to one; a nukta or candrabindu typed after a vowel sign moves back
+//     next to its consonant (e.g. "कँा" becomes "काँ")
+//   - vowel sign pairs that spell another sign are merged ("ाे" → "ो"), as
+//     are independent vowels written with a sign ("अा" → "आ"); a vowel sign
+//     replaces a virama before it, other second vowel signs and vowel signs
+//     without a consonant are dropped, except an i-sign written before its
+//     consonant which is moved after it
+//   - "|" and a "1" stuck to the end of a Devanagari word become danda, as
+//     does a "|" one space after it; two dandas become a double danda. A "|"
+//     in Latin text or a table ("A | B") is kept
+type NepaliNormalizer struct{}
+
+func (NepaliNormalizer) Filter(text string) string {
+	text = norm.NFC.String(stripInvisible(text))
+	in := []rune(text)
+	out := make([]rune, 0, len(in))
+
+	last := func(back int) rune {
+		if len(out) < back {
+			return 0
+		}
+		return out[len(out)-back]
+	}
+
+	for i := 0; i < len(in); i++ {
+		r := in[i]
+		switch {
+		case r == nukta:
+			switch prev := last(1); {
+			case prev == nukta:
+			case isConsonant(prev):
+				out = append(out, r)
+			case isVowelSign(prev) && isConsonant(last(2)):
+				// Move the nukta back to its consonant
+				out = append(out[:len(out)-1], r, prev)
+			}
+			// A nukta without a consonant is noise and is dropped
+
+		case isVowelSign(r):
+			prev := last(1)
+			switch {
+			case prev == r:
+			case isVowelSign(prev):
+				if merged, ok := matraPairs[[2]rune{prev, r}]; ok {
+					out[len(out)-1] = merged
+				}
+			case vowelPairs[[2]rune{prev, r}] != 0:
+				out[len(out)-1] = vowelPairs[[2]rune{prev, r}]
+			case prev == virama && isConsonant(last(2)):
+				// A vowel sign cannot follow a virama; the virama was misread
+				out[len(out)-1] = r
+			case isBindu(prev) && hasBase(last(2)):
+				// Vowel sign typed after candrabindu/anusvara
+				out = append(out[:len(out)-1], r, prev)
+			case hasBase(prev):
+				out = append(out, r)
+			case r == signI && i+1 < len(in) && isConsonant(in[i+1]):
+				// The i-sign is drawn before its consonant and sometimes read that way
+				out = append(out, in[i+1], r)
+				i++
+			}
+
+		case isBindu(r):
+			if prev := last(1); isBindu(prev) {
+				if r == candrabindu {
+					out[len(out)-1] = candrabindu
+				}
+				continue
+			}
+			out = append(out, r)
+
+		case r == virama:
+			if last(1) != virama {
+				out = append(out, r)
+			}
+
+		case r == '|' && (isDevanagariLetter(last(1)) || last(1) == danda || last(1) == ' ' && isDevanagariLetter(last(2))):
+			out = appendDanda(out)
+
+		case r == '1' && isDevanagariLetter(last(1)) && (i+1 == len(in) || !isDigit(in[i+1])):
+			out = appendDanda(out)
+
+		case r == danda:
+			out = appendDanda(out)
+
+		default:
+			out = append(out, r)
+		}
+	}
+	return string(out)
+}
+
+// appendDanda adds a danda, turning a danda right before it into a double danda
+func appendDanda(out []rune) []rune {
+	if n := len(out); n > 0 && out[n-1] == danda {
+		out[n-1] = doubleDanda
+		return out
+	}
+	return append(out, danda)
+}
+
+// stripInvisible removes zero width and formatting characters, keeping
+// ZWJ/ZWNJ right after a virama
+func stripInvisible(text string) string {
+	var b strings.Builder
+	var prev rune
+	for _, r := range text {
+		switch r {
+		case '\u200B', '\uFEFF', '\u00AD':
+			continue
+		case zwj, zwnj:
+			if prev != virama {
+				continue
+			}
+		}
+		b.WriteRune(r)
+		prev = r
+	}
+	return b.String()
+}
+
+func isConsonant(r rune) bool {
+	return (r >= 'क' && r <= 'ह') || (r >= 'क़' && r <= 'य़') || (r >= 'ॸ' && r <= 'ॿ')
+}
+
+func isVowelSign(r rune) bool {
+	return (r >= 'ा' && r <= 'ौ') || r == 'ॎ' || r == 'ॏ' || (r >= 'ॕ' && r <= 'ॗ') || r == 'ॢ' || r == 'ॣ'
+}
+
+func isBindu(r rune) bool {
+	return r == candrabindu || r == anusvara
+}
+
+// hasBase reports whether a vowel sign may follow r
+func hasBase(r rune) bool {
+	return isConsonant(r) || r == nukta
+}
+
+// isDevanagariLetter is any Devanagari letter or sign, but not a digit or danda
+func isDevanagariLetter(r rune) bool {
+	return r >= 'ऀ' && r <= 'ॣ'
+}
+
+func isDigit(r rune) bool {
+	return (r >= '0' && r <= '9') || (r >= '०' && r <= '९')
+}
@@ -0,0 +1,43 @@
+package textfilter
+
+import "testing"
+
+func TestNepaliNormalizer(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"nukta after vowel sign moves to its consonant", "का़", "क़ा"},
+		{"repeated nukta collapses", "क़़", "क़"},
+		{"nukta without consonant is dropped", "़क", "क"},
+		{"candrabindu after vowel sign moves to its consonant", "कँा", "काँ"},
+		{"candrabindu and anusvara collapse to candrabindu", "हंँ", "हँ"},
+		{"repeated anusvara collapses", "हंं", "हं"},
+		{"aa and e signs merge into o", "काे", "को"},
+		{"e and aa signs merge into o", "केा", "को"},
+		{"aa and ai signs merge into au", "काै", "कौ"},
+		{"a with aa sign becomes aa", "अाज", "आज"},
+		{"pipe becomes danda", "नेपाल|", "नेपाल।"},
+		{"one after a word becomes danda", "नेपाल1", "नेपाल।"},
+		{"pipe after a space becomes danda", "नेपाल |", "नेपाल ।"},
+		{"two pipes become double danda", "नेपाल||", "नेपाल॥"},
+		{"pipe in latin text is kept", "a|b", "a|b"},
+		{"pipe in a table is kept", "A | B", "A | B"},
+		{"pipe at the start is kept", "| नेपाल", "| नेपाल"},
+		{"two dandas become double danda", "नेपाल।।", "नेपाल॥"},
+		{"zwj after virama is kept", "क्\u200dष", "क्\u200dष"},
+		{"zwnj after virama is kept", "क्\u200cष", "क्\u200cष"},
+		{"zwj elsewhere is removed", "क\u200dख", "कख"},
+		{"zwnj elsewhere is removed", "क\u200cख", "कख"},
+		{"one after a space stays a digit", "वडा नं 1", "वडा नं 1"},
+		{"one in a number stays a digit", "नेपाल12", "नेपाल12"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := (NepaliNormalizer{}).Filter(tt.in); got != tt.want {
+				t.Errorf("Filter(%+q) = %+q, want %+q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
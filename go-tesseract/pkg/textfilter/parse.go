@@ -0,0 +1,94 @@
+package textfilter
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// ParseFilter builds one filter from a spec of the form "name" or
+// "name:argument", as given to the CLI -filter flag and the API filter field:
+//
+//	devanagari                 DevanagariCleanup
+//	nepali                     NepaliNormalizer
+//	script:devanagari+digits   ScriptFilter keeping the listed scripts and DefaultPunctuation
+//	require:devanagari         RequireScript
+//	whitespace                 Whitespace
+//	nfc                        NFC
+//	digits:ascii|devanagari    DigitConverter
+//	regex:PATTERN=>REPLACEMENT RegexRule (REPLACEMENT may be empty)
+//	noise:WORD,WORD,...        NoiseWords
+func ParseFilter(spec string) (TextFilter, error) {
+	name, arg, _ := strings.Cut(strings.TrimSpace(spec), ":")
+
+	switch strings.ToLower(name) {
+	case "devanagari":
+		return DevanagariCleanup(), nil
+	case "nepali":
+		return NepaliNormalizer{}, nil
+	case "script":
+		scripts, err := parseScripts(arg)
+		if err != nil {
+			return nil, err
+		}
+		return ScriptFilter{Scripts: scripts, Keep: DefaultPunctuation}, nil
+	case "require":
+		scripts, err := parseScripts(arg)
+		if err != nil {
+			return nil, err
+		}
+		return RequireScript{Scripts: scripts}, nil
+	case "whitespace":
+		return Whitespace{}, nil
+	case "nfc":
+		return NFC{}, nil
+	case "digits":
+		switch style := DigitStyle(strings.ToLower(arg)); style {
+		case DigitsASCII, DigitsDevanagari:
+			return DigitConverter{To: style}, nil
+		}
+		return nil, fmt.Errorf("unknown digit style %q (use ascii or devanagari)", arg)
+	case "regex":
+		pattern, replacement, _ := strings.Cut(arg, "=>")
+		if pattern == "" {
+			return nil, fmt.Errorf("regex filter needs a pattern, e.g. regex:[|]=>।")
+		}
+		return NewRegexRule(pattern, replacement)
+	case "noise":
+		words := strings.FieldsFunc(arg, func(r rune) bool { return r == ',' })
+		if len(words) == 0 {
+			return nil, fmt.Errorf("noise filter needs at least one word")
+		}
+		return NewNoiseWords(words...), nil
+	}
+	return nil, fmt.Errorf("unknown text filter %q (use devanagari, nepali, script, require, whitespace, nfc, digits, regex or noise)", name)
+}
+
+// Parse builds a chain from filter specs in order; see ParseFilter
+func Parse(specs []string) (Chain, error) {
+	var chain Chain
+	for _, spec := range specs {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+		f, err := ParseFilter(spec)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, f)
+	}
+	return chain, nil
+}
+
+// parseScripts resolves a "+" separated list of names from Scripts
+func parseScripts(arg string) ([]*unicode.RangeTable, error) {
+	var scripts []*unicode.RangeTable
+	for _, name := range strings.Split(arg, "+") {
+		table, ok := Scripts[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("unknown script %q (use devanagari, latin or digits)", name)
+		}
+		scripts = append(scripts, table)
+	}
+	return scripts, nil
+}
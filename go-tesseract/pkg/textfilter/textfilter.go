@@ -0,0 +1,199 @@
+// Package textfilter cleans up OCR output with small, ordered filters.
+//
+// A Chain of filters is applied to every line (or word) Tesseract returns.
+// Each filter gets the output of the previous one; a filter that returns
+// the empty string drops the text and ends the chain
+package textfilter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// TextFilter transforms one piece of OCR text. Returning "" drops it
+type TextFilter interface {
+	Filter(text string) string
+}
+
+// Func adapts an ordinary function to a TextFilter
+type Func func(text string) string
+
+func (f Func) Filter(text string) string {
+	return f(text)
+}
+
+// Chain applies its filters in order and stops at the first empty result
+type Chain []TextFilter
+
+func (c Chain) Filter(text string) string {
+	for _, f := range c {
+		text = f.Filter(text)
+		if text == "" {
+			return ""
+		}
+	}
+	return text
+}
+
+// Devanagari is the Unicode block used by Nepali, Hindi and Marathi
+var Devanagari = &unicode.RangeTable{
+	R16: []unicode.Range16{{Lo: 0x0900, Hi: 0x097F, Stride: 1}},
+}
+
+// Latin is basic and extended Latin letters
+var Latin = unicode.Latin
+
+// ASCIIDigits is 0-9
+var ASCIIDigits = &unicode.RangeTable{
+	R16:         []unicode.Range16{{Lo: '0', Hi: '9', Stride: 1}},
+	LatinOffset: 1,
+}
+
+// Scripts maps the script names accepted by Parse to their ranges
+var Scripts = map[string]*unicode.RangeTable{
+	"devanagari": Devanagari,
+	"latin":      Latin,
+	"digits":     ASCIIDigits,
+}
+
+// DefaultPunctuation is the punctuation kept by ScriptFilter when Keep is empty
+const DefaultPunctuation = ".,?!।-()"
+
+// ScriptFilter replaces every run of characters outside Scripts, Keep and
+// whitespace with a single space. Replacing rather than deleting avoids
+// gluing neighbouring words together
+type ScriptFilter struct {
+	Scripts []*unicode.RangeTable
+	Keep    string
+}
+
+func (f ScriptFilter) Filter(text string) string {
+	var b strings.Builder
+	dropping := false
+	for _, r := range text {
+		if unicode.IsSpace(r) || strings.ContainsRune(f.Keep, r) || unicode.In(r, f.Scripts...) {
+			b.WriteRune(r)
+			dropping = false
+			continue
+		}
+		if !dropping {
+			b.WriteByte(' ')
+			dropping = true
+		}
+	}
+	return b.String()
+}
+
+// RequireScript drops text that has no character from Scripts, e.g. lines
+// that are only digits and punctuation once the noise is gone
+type RequireScript struct {
+	Scripts []*unicode.RangeTable
+}
+
+func (f RequireScript) Filter(text string) string {
+	for _, r := range text {
+		if unicode.In(r, f.Scripts...) {
+			return text
+		}
+	}
+	return ""
+}
+
+// Whitespace collapses runs of whitespace into one space and trims the ends
+type Whitespace struct{}
+
+func (Whitespace) Filter(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// NFC composes text into Unicode Normalization Form C so the same word
+// always has the same bytes, whatever order Tesseract emitted the marks in
+type NFC struct{}
+
+func (NFC) Filter(text string) string {
+	return norm.NFC.String(text)
+}
+
+// DigitStyle selects the digits DigitConverter writes
+type DigitStyle string
+
+const (
+	DigitsASCII      DigitStyle = "ascii"
+	DigitsDevanagari DigitStyle = "devanagari"
+)
+
+// DigitConverter rewrites ASCII digits as Devanagari digits or the reverse
+type DigitConverter struct {
+	To DigitStyle
+}
+
+func (f DigitConverter) Filter(text string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case f.To == DigitsDevanagari && r >= '0' && r <= '9':
+			return '०' + (r - '0')
+		case f.To == DigitsASCII && r >= '०' && r <= '९':
+			return '0' + (r - '०')
+		}
+		return r
+	}, text)
+}
+
+// RegexRule replaces every match of Pattern with Replacement, which may
+// refer to groups as in regexp.Regexp.ReplaceAllString
+type RegexRule struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// NewRegexRule compiles pattern into a RegexRule
+func NewRegexRule(pattern, replacement string) (RegexRule, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return RegexRule{}, fmt.Errorf("invalid regex rule %q: %w", pattern, err)
+	}
+	return RegexRule{Pattern: re, Replacement: replacement}, nil
+}
+
+func (f RegexRule) Filter(text string) string {
+	return f.Pattern.ReplaceAllString(text, f.Replacement)
+}
+
+// NoiseWords removes whitespace separated tokens found in Words, such as
+// stray marks Tesseract reads from stamps and ruling lines
+type NoiseWords struct {
+	Words map[string]bool
+}
+
+// NewNoiseWords builds a NoiseWords filter for words
+func NewNoiseWords(words ...string) NoiseWords {
+	set := make(map[string]bool, len(words))
+	for _, w := range words {
+		set[w] = true
+	}
+	return NoiseWords{Words: set}
+}
+
+func (f NoiseWords) Filter(text string) string {
+	var kept []string
+	for _, token := range strings.Fields(text) {
+		if !f.Words[token] {
+			kept = append(kept, token)
+		}
+	}
+	return strings.Join(kept, " ")
+}
+
+// DevanagariCleanup is the classic cleanup for Nepali OCR text: it drops
+// everything except Devanagari, ASCII digits and basic punctuation, then
+// normalizes whitespace
+func DevanagariCleanup() Chain {
+	return Chain{
+		ScriptFilter{Scripts: []*unicode.RangeTable{Devanagari, ASCIIDigits}, Keep: DefaultPunctuation},
+		Whitespace{},
+	}
+}
@@ -0,0 +1,124 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// deliveriesBucket holds every delivery as JSON, keyed by "<job ID>/<delivery ID>"
+var deliveriesBucket = []byte("deliveries")
+
+// Store persists deliveries and their attempts in a bbolt file
+type Store struct {
+	db *bolt.DB
+}
+
+// OpenStore opens or creates the delivery log at path
+func OpenStore(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open delivery log: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(deliveriesBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create delivery bucket: %w", err)
+	}
+	return &Store{db: db}, nil
+}
+
+// Close releases the database file
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func deliveryKey(d *Delivery) []byte {
+	return []byte(d.JobID + "/" + d.ID)
+}
+
+// put saves d, replacing any earlier version
+func (s *Store) put(d *Delivery) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(deliveriesBucket).Put(deliveryKey(d), data)
+	})
+}
+
+// forJob returns the deliveries of one job, in key order
+func (s *Store) forJob(jobID string) ([]*Delivery, error) {
+	prefix := []byte(jobID + "/")
+	var out []*Delivery
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(deliveriesBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var d Delivery
+			if err := json.Unmarshal(v, &d); err != nil {
+				return err
+			}
+			out = append(out, &d)
+		}
+		return nil
+	})
+	return out, err
+}
+
+// prune deletes the delivered and dead deliveries whose last attempt was
+// before cutoff and returns how many it deleted
+func (s *Store) prune(cutoff time.Time) (int, error) {
+	var expired [][]byte
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(deliveriesBucket)
+		// Keys are collected first: deleting under a cursor skips entries
+		err := b.ForEach(func(k, v []byte) error {
+			var d Delivery
+			if err := json.Unmarshal(v, &d); err != nil {
+				return err
+			}
+			if d.State != StatePending && d.finishedAt().Before(cutoff) {
+				expired = append(expired, bytes.Clone(k))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(expired), nil
+}
+
+// withState returns every delivery in state
+func (s *Store) withState(state State) ([]*Delivery, error) {
+	var out []*Delivery
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(deliveriesBucket).ForEach(func(_, v []byte) error {
+			var d Delivery
+			if err := json.Unmarshal(v, &d); err != nil {
+				return err
+			}
+			if d.State == state {
+				out = append(out, &d)
+			}
+			return nil
+		})
+	})
+	return out, err
+}
@@ -0,0 +1,424 @@
+// Package webhook POSTs job results to caller-supplied callback URLs.
+// Every request is signed with an HMAC of its body, failed deliveries are
+// retried with exponential backoff, and after MaxAttempts failures the
+// delivery is kept as a dead letter. Deliveries and all their attempts are
+// logged in a Store and pending ones resume after a restart.
+//
+// Callback URLs come from callers, so unless Options.AllowPrivate is set the
+// dispatcher refuses to connect to loopback, private, link-local and cloud
+// metadata addresses, checked on the resolved address of every connection,
+// and it never follows redirects
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Headers sent with every delivery
+const (
+	// SignatureHeader carries "sha256=" and the hex HMAC-SHA256 of
+	// "<timestamp>.<body>" keyed with the shared secret
+	SignatureHeader = "X-OCR-Signature"
+	// TimestampHeader is the Unix time the request was signed at, so
+	// receivers can reject replays
+	TimestampHeader = "X-OCR-Timestamp"
+	EventHeader     = "X-OCR-Event"
+	DeliveryHeader  = "X-OCR-Delivery"
+)
+
+// ErrForbiddenAddress is returned for callbacks to loopback, private,
+// link-local and similar addresses unless Options.AllowPrivate is set
+var ErrForbiddenAddress = errors.New("callback address is not allowed")
+
+// sharedAddressSpace is the carrier-grade NAT range of RFC 6598, which
+// netip does not count as private
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// State is where a delivery is in its life
+type State string
+
+const (
+	StatePending   State = "pending"
+	StateDelivered State = "delivered"
+	StateDead      State = "dead"
+)
+
+// Attempt is one POST to the callback URL
+type Attempt struct {
+	At         time.Time `json:"at"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMS int64     `json:"duration_ms"`
+}
+
+// Delivery is one event to send to one callback URL
+type Delivery struct {
+	ID    string `json:"id"`
+	JobID string `json:"job_id"`
+	// Owner is the owner of the job, e.g. the ID of its API key
+	Owner       string     `json:"owner,omitempty"`
+	URL         string     `json:"url"`
+	Event       string     `json:"event"`
+	State       State      `json:"state"`
+	Attempts    []Attempt  `json:"attempts"`
+	NextAttempt *time.Time `json:"next_attempt,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	// Payload is the request body, kept for retries
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// finishedAt is the time of the last attempt, or of creation before any
+func (d *Delivery) finishedAt() time.Time {
+	if n := len(d.Attempts); n > 0 {
+		return d.Attempts[n-1].At
+	}
+	return d.CreatedAt
+}
+
+// Options tunes the dispatcher. Zero fields take the defaults listed
+type Options struct {
+	// Secret keys the HMAC signature
+	Secret string
+	// MaxAttempts is how many POSTs are tried before a delivery is dead (5)
+	MaxAttempts int
+	// Backoff is the wait after the first failure, doubled after every
+	// further one (1s) up to MaxBackoff (10m)
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Timeout bounds one POST (10s)
+	Timeout time.Duration
+	// Retention is how long delivered and dead deliveries stay in the log
+	// after their last attempt (forever when zero)
+	Retention time.Duration
+	// AllowPrivate lets callbacks reach loopback, private and link-local
+	// addresses, e.g. a receiver on the same host or network
+	AllowPrivate bool
+	// Client sends the requests. When nil, a client with Timeout that
+	// refuses redirects and, unless AllowPrivate, forbidden addresses
+	Client *http.Client
+}
+
+func (o Options) withDefaults() Options {
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = 5
+	}
+	if o.Backoff <= 0 {
+		o.Backoff = time.Second
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = 10 * time.Minute
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 10 * time.Second
+	}
+	if o.Client == nil {
+		o.Client = newClient(o)
+	}
+	return o
+}
+
+// newClient returns a client that does not follow redirects, which could
+// lead anywhere, and checks every address it connects to after DNS
+// resolution, so a name that resolves to an internal address is caught too
+func newClient(o Options) *http.Client {
+	dialer := &net.Dialer{Timeout: o.Timeout}
+	if !o.AllowPrivate {
+		dialer.Control = func(_, address string, _ syscall.RawConn) error {
+			addr, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if forbiddenAddr(addr.Addr()) {
+				return fmt.Errorf("%w: %s", ErrForbiddenAddress, addr.Addr())
+			}
+			return nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   o.Timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// forbiddenAddr reports whether addr is loopback, private, link-local
+// (which holds the 169.254.169.254 metadata service), carrier-grade NAT,
+// multicast or unspecified
+func forbiddenAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() ||
+		addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() || addr.IsMulticast() ||
+		addr.IsUnspecified() || sharedAddressSpace.Contains(addr)
+}
+
+// Dispatcher sends deliveries in the background
+type Dispatcher struct {
+	store *Store
+	opts  Options
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New starts a dispatcher and resumes the deliveries left pending by an
+// earlier run
+func New(store *Store, opts Options) (*Dispatcher, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &Dispatcher{store: store, opts: opts.withDefaults(), ctx: ctx, cancel: cancel}
+
+	pending, err := store.withState(StatePending)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to load pending deliveries: %w", err)
+	}
+	for _, delivery := range pending {
+		d.start(delivery)
+	}
+	if d.opts.Retention > 0 {
+		d.wg.Add(1)
+		go d.pruner()
+	}
+	return d, nil
+}
+
+// ValidateURL checks that a callback URL is an absolute http(s) URL
+func ValidateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid callback URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("callback URL must be an absolute http or https URL")
+	}
+	return nil
+}
+
+// ValidateURL is the package ValidateURL that also rejects callback URLs
+// naming a forbidden address or localhost outright, unless the dispatcher
+// allows private addresses. Names resolving to such addresses are only
+// caught when the delivery connects
+func (d *Dispatcher) ValidateURL(raw string) error {
+	if err := ValidateURL(raw); err != nil {
+		return err
+	}
+	if d.opts.AllowPrivate {
+		return nil
+	}
+	u, _ := url.Parse(raw)
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, host)
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && forbiddenAddr(addr) {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, addr)
+	}
+	return nil
+}
+
+// Enqueue records a delivery of payload about a job of owner to callbackURL
+// and sends it in the background
+func (d *Dispatcher) Enqueue(jobID, owner, callbackURL, event string, payload []byte) (*Delivery, error) {
+	delivery := &Delivery{
+		ID:        uuid.NewString(),
+		JobID:     jobID,
+		Owner:     owner,
+		URL:       callbackURL,
+		Event:     event,
+		State:     StatePending,
+		Attempts:  []Attempt{},
+		CreatedAt: time.Now(),
+		Payload:   payload,
+	}
+	if err := d.store.put(delivery); err != nil {
+		return nil, fmt.Errorf("failed to record delivery: %w", err)
+	}
+	d.start(delivery)
+	return delivery, nil
+}
+
+// Deliveries returns the delivery log of a job, oldest first
+func (d *Dispatcher) Deliveries(jobID string) ([]*Delivery, error) {
+	out, err := d.store.forJob(jobID)
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out, err
+}
+
+// DeadLetters returns the deliveries that failed MaxAttempts times, oldest first
+func (d *Dispatcher) DeadLetters() ([]*Delivery, error) {
+	out, err := d.store.withState(StateDead)
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out, err
+}
+
+// Prune deletes the delivered and dead deliveries whose last attempt was
+// more than Options.Retention ago and returns how many it deleted
+func (d *Dispatcher) Prune() (int, error) {
+	if d.opts.Retention <= 0 {
+		return 0, nil
+	}
+	return d.store.prune(time.Now().Add(-d.opts.Retention))
+}
+
+// pruner runs Prune every tenth of the retention, but at least hourly and
+// at most every second, until the dispatcher is closed
+func (d *Dispatcher) pruner() {
+	defer d.wg.Done()
+	ticker := time.NewTicker(max(min(d.opts.Retention/10, time.Hour), time.Second))
+	defer ticker.Stop()
+	for {
+		if pruned, err := d.Prune(); err != nil {
+			log.Printf("webhooks: failed to prune the delivery log: %v", err)
+		} else if pruned > 0 {
+			log.Printf("webhooks: removed %d deliveries older than %s", pruned, d.opts.Retention)
+		}
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close stops retrying. Pending deliveries stay pending and resume on the next New
+func (d *Dispatcher) Close() {
+	d.cancel()
+	d.wg.Wait()
+}
+
+func (d *Dispatcher) start(delivery *Delivery) {
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		d.run(delivery)
+	}()
+}
+
+// run attempts the delivery until it succeeds, runs out of attempts or
+// the dispatcher is closed
+func (d *Dispatcher) run(delivery *Delivery) {
+	for {
+		if delivery.NextAttempt != nil {
+			select {
+			case <-time.After(time.Until(*delivery.NextAttempt)):
+			case <-d.ctx.Done():
+				return
+			}
+		}
+
+		attempt := d.post(delivery)
+		if d.ctx.Err() != nil {
+			// Cut off by Close: try again after the restart
+			return
+		}
+		delivery.Attempts = append(delivery.Attempts, attempt)
+		delivery.NextAttempt = nil
+
+		switch {
+		case attempt.Error == "":
+			delivery.State = StateDelivered
+		case len(delivery.Attempts) >= d.opts.MaxAttempts:
+			delivery.State = StateDead
+			log.Printf("webhook %s for job %s is dead after %d attempts: %s",
+				delivery.ID, delivery.JobID, len(delivery.Attempts), attempt.Error)
+		default:
+			next := time.Now().Add(d.backoff(len(delivery.Attempts)))
+			delivery.NextAttempt = &next
+		}
+
+		if err := d.store.put(delivery); err != nil {
+			log.Printf("webhook %s: failed to save: %v", delivery.ID, err)
+		}
+		if delivery.State != StatePending {
+			return
+		}
+	}
+}
+
+// backoff is the wait after the n-th failed attempt
+func (d *Dispatcher) backoff(n int) time.Duration {
+	wait := d.opts.Backoff
+	for i := 1; i < n && wait < d.opts.MaxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, d.opts.MaxBackoff)
+}
+
+// post sends one signed request. Anything but a 2xx answer is a failure,
+// redirects included
+func (d *Dispatcher) post(delivery *Delivery) Attempt {
+	start := time.Now()
+	attempt := Attempt{At: start}
+
+	ctx, cancel := context.WithTimeout(d.ctx, d.opts.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		attempt.Error = err.Error()
+		attempt.DurationMS = time.Since(start).Milliseconds()
+		return attempt
+	}
+
+	timestamp := strconv.FormatInt(start.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(d.opts.Secret, timestamp, delivery.Payload))
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, delivery.ID)
+
+	resp, err := d.opts.Client.Do(req)
+	if err != nil {
+		attempt.Error = err.Error()
+		attempt.DurationMS = time.Since(start).Milliseconds()
+		return attempt
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+
+	attempt.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		attempt.Error = fmt.Sprintf("callback answered %s", resp.Status)
+	}
+	attempt.DurationMS = time.Since(start).Milliseconds()
+	return attempt
+}
+
+// Sign returns the SignatureHeader value for body sent at timestamp
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a SignatureHeader value in constant time, for receivers written in Go
+func Verify(secret, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(strings.TrimSpace(signature)))
+}
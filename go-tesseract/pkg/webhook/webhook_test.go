@@ -0,0 +1,354 @@
+package webhook
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testSecret = "s3cret"
+
+// receiver records the requests of a callback test server and answers
+// them with the status codes in answers, then with the last one
+type receiver struct {
+	mu       sync.Mutex
+	answers  []int
+	requests []received
+}
+
+type received struct {
+	at      time.Time
+	header  http.Header
+	body    []byte
+	checked bool
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, received{
+		at:      time.Now(),
+		header:  req.Header.Clone(),
+		body:    body,
+		checked: Verify(testSecret, req.Header.Get(TimestampHeader), body, req.Header.Get(SignatureHeader)),
+	})
+	status := r.answers[min(len(r.requests), len(r.answers))-1]
+	w.WriteHeader(status)
+}
+
+func (r *receiver) received() []received {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]received(nil), r.requests...)
+}
+
+// newDispatcher starts a dispatcher with a fresh store and quick retries.
+// httptest servers listen on loopback, so private addresses are allowed
+func newDispatcher(t *testing.T, opts Options) *Dispatcher {
+	t.Helper()
+	store, err := OpenStore(filepath.Join(t.TempDir(), "webhooks.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.Secret = testSecret
+	opts.AllowPrivate = true
+	if opts.Backoff == 0 {
+		opts.Backoff = 20 * time.Millisecond
+	}
+	d, err := New(store, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		d.Close()
+		store.Close()
+	})
+	return d
+}
+
+// waitDone waits until the only delivery of job is no longer pending
+func waitDone(t *testing.T, d *Dispatcher, jobID string) *Delivery {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		deliveries, err := d.Deliveries(jobID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(deliveries) == 1 && deliveries[0].State != StatePending {
+			return deliveries[0]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("delivery of %s still pending", jobID)
+	return nil
+}
+
+func TestSignVerify(t *testing.T) {
+	body := []byte(`{"id":"job-1","status":"succeeded"}`)
+	signature := Sign(testSecret, "1700000000", body)
+	if !strings.HasPrefix(signature, "sha256=") {
+		t.Fatalf("signature %q lacks the sha256= prefix", signature)
+	}
+
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		body      []byte
+		want      bool
+	}{
+		{"same request", testSecret, "1700000000", body, true},
+		{"other secret", "other", "1700000000", body, false},
+		{"other timestamp", testSecret, "1700000001", body, false},
+		{"other body", testSecret, "1700000000", []byte(`{"id":"job-2","status":"succeeded"}`), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Verify(tt.secret, tt.timestamp, tt.body, signature); got != tt.want {
+				t.Errorf("Verify = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeliverySigned(t *testing.T) {
+	recv := &receiver{answers: []int{http.StatusOK}}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	d := newDispatcher(t, Options{})
+	payload := []byte(`{"id":"job-1"}`)
+	delivery, err := d.Enqueue("job-1", "dms", server.URL, "job.succeeded", payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := waitDone(t, d, "job-1")
+	if done.State != StateDelivered || len(done.Attempts) != 1 {
+		t.Fatalf("state %s after %d attempts, want delivered after 1", done.State, len(done.Attempts))
+	}
+	requests := recv.received()
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(requests))
+	}
+	req := requests[0]
+	if !req.checked {
+		t.Error("signature does not verify")
+	}
+	if string(req.body) != string(payload) {
+		t.Errorf("body %s, want %s", req.body, payload)
+	}
+	if got := req.header.Get(EventHeader); got != "job.succeeded" {
+		t.Errorf("%s = %q", EventHeader, got)
+	}
+	if got := req.header.Get(DeliveryHeader); got != delivery.ID {
+		t.Errorf("%s = %q, want %q", DeliveryHeader, got, delivery.ID)
+	}
+}
+
+func TestDeliveryRetriesWithBackoff(t *testing.T) {
+	recv := &receiver{answers: []int{http.StatusInternalServerError, http.StatusServiceUnavailable, http.StatusNoContent}}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	backoff := 50 * time.Millisecond
+	d := newDispatcher(t, Options{Backoff: backoff})
+	if _, err := d.Enqueue("job-1", "dms", server.URL, "job.succeeded", []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	done := waitDone(t, d, "job-1")
+	if done.State != StateDelivered {
+		t.Fatalf("state %s, want delivered", done.State)
+	}
+	wantCodes := []int{500, 503, 204}
+	if len(done.Attempts) != len(wantCodes) {
+		t.Fatalf("%d attempts, want %d", len(done.Attempts), len(wantCodes))
+	}
+	for i, a := range done.Attempts {
+		if a.StatusCode != wantCodes[i] {
+			t.Errorf("attempt %d answered %d, want %d", i+1, a.StatusCode, wantCodes[i])
+		}
+		if (a.Error == "") != (i == len(wantCodes)-1) {
+			t.Errorf("attempt %d error %q", i+1, a.Error)
+		}
+	}
+
+	// Every retry is signed again and waits twice as long as the one before
+	requests := recv.received()
+	for i, req := range requests {
+		if !req.checked {
+			t.Errorf("request %d: signature does not verify", i+1)
+		}
+		if i == 0 {
+			continue
+		}
+		want := backoff << (i - 1)
+		if gap := req.at.Sub(requests[i-1].at); gap < want {
+			t.Errorf("retry %d came after %s, want at least %s", i, gap, want)
+		}
+	}
+}
+
+func TestDeadLetter(t *testing.T) {
+	recv := &receiver{answers: []int{http.StatusBadGateway}}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	d := newDispatcher(t, Options{MaxAttempts: 3})
+	delivery, err := d.Enqueue("job-1", "dms", server.URL, "job.failed", []byte(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := waitDone(t, d, "job-1")
+	if done.State != StateDead || len(done.Attempts) != 3 {
+		t.Fatalf("state %s after %d attempts, want dead after 3", done.State, len(done.Attempts))
+	}
+	if n := len(recv.received()); n != 3 {
+		t.Errorf("receiver got %d requests, want 3", n)
+	}
+
+	dead, err := d.DeadLetters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 1 || dead[0].ID != delivery.ID {
+		t.Fatalf("dead letters %v, want only %s", dead, delivery.ID)
+	}
+	if dead[0].Owner != "dms" {
+		t.Errorf("dead letter owner %q, want dms", dead[0].Owner)
+	}
+}
+
+func TestPruneKeepsPendingAndRecent(t *testing.T) {
+	d := newDispatcher(t, Options{})
+	now := time.Now()
+	old, recent := now.Add(-2*time.Hour), now.Add(-10*time.Minute)
+
+	deliveries := []*Delivery{
+		{ID: "old-delivered", JobID: "job-1", State: StateDelivered, CreatedAt: old, Attempts: []Attempt{{At: old}}},
+		{ID: "old-dead", JobID: "job-2", State: StateDead, CreatedAt: old, Attempts: []Attempt{{At: old}}},
+		{ID: "recent-dead", JobID: "job-3", State: StateDead, CreatedAt: old, Attempts: []Attempt{{At: old}, {At: recent}}},
+		{ID: "old-pending", JobID: "job-4", State: StatePending, CreatedAt: old, Attempts: []Attempt{{At: old}}},
+	}
+	for _, delivery := range deliveries {
+		if err := d.store.put(delivery); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pruned, err := d.store.prune(now.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pruned != 2 {
+		t.Errorf("pruned %d deliveries, want 2", pruned)
+	}
+	for _, tt := range []struct {
+		jobID string
+		kept  bool
+	}{{"job-1", false}, {"job-2", false}, {"job-3", true}, {"job-4", true}} {
+		left, err := d.Deliveries(tt.jobID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kept := len(left) == 1; kept != tt.kept {
+			t.Errorf("delivery of %s kept = %v, want %v", tt.jobID, kept, tt.kept)
+		}
+	}
+}
+
+func TestRedirectNotFollowed(t *testing.T) {
+	target := &receiver{answers: []int{http.StatusOK}}
+	targetServer := httptest.NewServer(target)
+	defer targetServer.Close()
+	redirect := httptest.NewServer(http.RedirectHandler(targetServer.URL, http.StatusTemporaryRedirect))
+	defer redirect.Close()
+
+	d := newDispatcher(t, Options{MaxAttempts: 1})
+	if _, err := d.Enqueue("job-1", "dms", redirect.URL, "job.succeeded", []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	done := waitDone(t, d, "job-1")
+	if done.State != StateDead || done.Attempts[0].StatusCode != http.StatusTemporaryRedirect {
+		t.Fatalf("state %s with %+v, want dead after a 307", done.State, done.Attempts)
+	}
+	if n := len(target.received()); n != 0 {
+		t.Errorf("redirect target got %d requests", n)
+	}
+}
+
+func TestPrivateAddressRefused(t *testing.T) {
+	recv := &receiver{answers: []int{http.StatusOK}}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	store, err := OpenStore(filepath.Join(t.TempDir(), "webhooks.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	d, err := New(store, Options{Secret: testSecret, MaxAttempts: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	// Enqueue does not validate, so only the dial stands between the
+	// delivery and the loopback address
+	if _, err := d.Enqueue("job-1", "dms", server.URL, "job.succeeded", []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	done := waitDone(t, d, "job-1")
+	if done.State != StateDead || !strings.Contains(done.Attempts[0].Error, ErrForbiddenAddress.Error()) {
+		t.Fatalf("state %s with %+v, want dead with a forbidden address", done.State, done.Attempts)
+	}
+	if n := len(recv.received()); n != 0 {
+		t.Errorf("receiver got %d requests", n)
+	}
+}
+
+func TestValidateURL(t *testing.T) {
+	d := &Dispatcher{}
+	tests := []struct {
+		url string
+		ok  bool
+	}{
+		{"https://dms.example.com/hook", true},
+		{"http://203.0.113.7:8080/hook", true},
+		{"ftp://dms.example.com/hook", false},
+		{"/hook", false},
+		{"http://localhost:8080/hook", false},
+		{"http://127.0.0.1/hook", false},
+		{"http://10.1.2.3/hook", false},
+		{"http://192.168.1.1/hook", false},
+		{"http://169.254.169.254/latest/meta-data/", false},
+		{"http://100.64.0.1/hook", false},
+		{"http://[::1]/hook", false},
+		{"http://[fd00::1]/hook", false},
+		{"http://[::ffff:127.0.0.1]/hook", false},
+		{"http://0.0.0.0/hook", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			if err := d.ValidateURL(tt.url); (err == nil) != tt.ok {
+				t.Errorf("ValidateURL = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+
+	d.opts.AllowPrivate = true
+	if err := d.ValidateURL("http://127.0.0.1/hook"); err != nil {
+		t.Errorf("ValidateURL with AllowPrivate = %v", err)
+	}
+}